@@ -0,0 +1,271 @@
+// Package art implements a content-addressed cache of cover art.
+//
+// Images are stored once by the hash of their contents, so songs only need to
+// carry a short URL instead of the image itself.
+package art
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// URLPrefix is the path under which art is served.
+const URLPrefix = "/api/art/"
+
+const dbBucket = "art"
+
+// ErrNotFound is returned by Get for unknown hashes.
+var ErrNotFound = errors.New("art: not found")
+
+// Cache stores art by the hash of its contents.
+type Cache struct {
+	mu sync.Mutex
+	db *bolt.DB
+	// known is the set of hashes already stored, to avoid a database
+	// transaction per song of an album.
+	known map[string]bool
+	// mem holds images until SetDB is called.
+	mem map[string][]byte
+}
+
+// New returns an empty Cache that holds art in memory until SetDB is
+// called.
+func New() *Cache {
+	return &Cache{
+		known: make(map[string]bool),
+		mem:   make(map[string][]byte),
+	}
+}
+
+// Default is the cache used by Put and Folder, which codecs call while
+// reading tags. Servers set it to their own cache.
+var Default = New()
+
+// Put stores data in the Default cache.
+func Put(mime string, data []byte) string {
+	return Default.Put(mime, data)
+}
+
+// Folder looks for a cover image in dir and stores it in the Default cache.
+func Folder(dir string) string {
+	return Default.Folder(dir)
+}
+
+// SetDB sets the database used to persist art. Any art stored before SetDB
+// was called is moved into db.
+func (c *Cache) SetDB(d *bolt.DB) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := d.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(dbBucket))
+		if err != nil {
+			return err
+		}
+		for h, v := range c.mem {
+			if err := b.Put([]byte(h), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.db = d
+	c.mem = make(map[string][]byte)
+	return nil
+}
+
+// URL returns the URL at which the art with hash is served.
+func URL(hash string) string {
+	return URLPrefix + hash
+}
+
+// Hash returns the hash of the art served at url, or "" if url is not an
+// art URL.
+func Hash(url string) string {
+	if !strings.HasPrefix(url, URLPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(url, URLPrefix)
+}
+
+// Put stores data of type mime and returns the URL it is served at. If mime is
+// "-->", data is a URL to an external image and is returned unchanged.
+func (c *Cache) Put(mime string, data []byte) string {
+	if mime == "-->" {
+		return string(data)
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	if mime == "" {
+		mime = sniff(data)
+	}
+	s := sha1.Sum(data)
+	h := hex.EncodeToString(s[:])
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.known[h] {
+		return URL(h)
+	}
+	v := append([]byte(mime+"\n"), data...)
+	if c.db == nil {
+		c.mem[h] = v
+	} else {
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(dbBucket))
+			if b.Get([]byte(h)) != nil {
+				return nil
+			}
+			return b.Put([]byte(h), v)
+		})
+		if err != nil {
+			return ""
+		}
+	}
+	c.known[h] = true
+	return URL(h)
+}
+
+// Has reports whether the art with hash is stored.
+func (c *Cache) Has(hash string) (bool, error) {
+	c.mu.Lock()
+	d := c.db
+	_, ok := c.mem[hash]
+	c.mu.Unlock()
+	if ok || d == nil {
+		return ok, nil
+	}
+	err := d.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(dbBucket)); b != nil {
+			ok = b.Get([]byte(hash)) != nil
+		}
+		return nil
+	})
+	return ok, err
+}
+
+// Get returns the mime type and data of the art with hash.
+func (c *Cache) Get(hash string) (mime string, data []byte, err error) {
+	c.mu.Lock()
+	d := c.db
+	v := c.mem[hash]
+	c.mu.Unlock()
+	if d != nil {
+		err = d.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte(dbBucket)); b != nil {
+				// Copy since the value is only valid during the transaction.
+				v = append([]byte(nil), b.Get([]byte(hash))...)
+			}
+			return nil
+		})
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	i := bytes.IndexByte(v, '\n')
+	if i < 0 {
+		return "", nil, ErrNotFound
+	}
+	return string(v[:i]), v[i+1:], nil
+}
+
+// Prune removes the art whose hash is not in keep, and returns the number
+// removed.
+func (c *Cache) Prune(keep map[string]bool) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for h := range c.mem {
+		if !keep[h] {
+			delete(c.mem, h)
+			delete(c.known, h)
+			n++
+		}
+	}
+	if c.db == nil {
+		return n, nil
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbBucket))
+		if b == nil {
+			return nil
+		}
+		var remove [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if !keep[string(k)] {
+				remove = append(remove, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, k := range remove {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+			delete(c.known, string(k))
+		}
+		n += len(remove)
+		return nil
+	})
+	return n, err
+}
+
+// FolderNames are the file names, without extension, searched for by Folder.
+var FolderNames = []string{"cover", "folder", "front", "album"}
+
+var folderExts = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+// Folder looks for a cover image (folder.jpg, cover.png, etc.) in dir and
+// returns its URL, or "" if there is none.
+func (c *Cache) Folder(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, name := range FolderNames {
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			ext := strings.ToLower(filepath.Ext(e.Name()))
+			mime, ok := folderExts[ext]
+			if !ok || !strings.EqualFold(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())), name) {
+				continue
+			}
+			b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				continue
+			}
+			return c.Put(mime, b)
+		}
+	}
+	return ""
+}
+
+func sniff(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG")):
+		return "image/png"
+	case bytes.HasPrefix(b, []byte("GIF8")):
+		return "image/gif"
+	}
+	return "application/octet-stream"
+}
@@ -7,7 +7,6 @@ package codec
 import (
 	"bufio"
 	"bytes"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -17,6 +16,7 @@ import (
 	"strings"
 
 	"github.com/dhowden/tag"
+	"github.com/mjibson/moggio/art"
 )
 
 // ErrFormat indicates that decoding encountered an unknown format.
@@ -141,46 +141,101 @@ func sniff(r reader) *codec {
 // or unknown).
 type Reader func() (io.ReadCloser, int64, error)
 
-func (rf Reader) Metadata(ft tag.FileType) (*SongInfo, tag.Metadata, []byte, error) {
+// Metadata reads the tags of a file of type ft. Only the tag regions are read
+// if the file is seekable; otherwise the whole file is read into memory. The
+// returned reader is positioned at the start of the file and must be closed
+// by the caller. The size is the file size in bytes.
+func (rf Reader) Metadata(ft tag.FileType) (*SongInfo, tag.Metadata, io.ReadSeekCloser, int64, error) {
 	r, sz, err := rf()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, 0, err
 	}
 	if sz == 0 {
-		return nil, nil, nil, fmt.Errorf("cannot get metadata with unknown size")
+		r.Close()
+		return nil, nil, nil, 0, fmt.Errorf("cannot get metadata with unknown size")
 	}
-	b, err := ioutil.ReadAll(r)
-	r.Close()
-	if err != nil {
-		return nil, nil, nil, err
+	rs, ok := r.(io.ReadSeekCloser)
+	if !ok {
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, nil, nil, 0, err
+		}
+		rs = nopCloser{bytes.NewReader(b)}
+	}
+	m, err := tag.ReadFrom(rs)
+	if err == nil && m.FileType() != ft {
+		err = fmt.Errorf("expected filetype %v, got %v", ft, m.FileType())
+	}
+	// Untagged files are still playable, so only report real errors.
+	if err == tag.ErrNoTagsFound {
+		err = nil
+	}
+	if err == nil {
+		_, err = rs.Seek(0, io.SeekStart)
 	}
-	m, err := tag.ReadFrom(bytes.NewReader(b))
 	if err != nil {
-		return nil, nil, nil, err
+		rs.Close()
+		return nil, nil, nil, 0, err
 	}
-	if m.FileType() != ft {
-		return nil, nil, nil, fmt.Errorf("expected filetype %v, got %v", ft, m.FileType())
+	if m == nil {
+		return &SongInfo{}, nil, rs, sz, nil
 	}
-	track, _ := m.Track()
+	track, trackTotal := m.Track()
+	disc, discTotal := m.Disc()
 	si := &SongInfo{
-		Artist:   m.Artist(),
-		Title:    m.Title(),
-		Album:    m.Album(),
-		Track:    float64(track),
-		ImageURL: dataURL(m),
+		Artist:      m.Artist(),
+		Title:       m.Title(),
+		Album:       m.Album(),
+		AlbumArtist: m.AlbumArtist(),
+		Track:       float64(track),
+		TrackTotal:  trackTotal,
+		Disc:        disc,
+		DiscTotal:   discTotal,
+		Year:        m.Year(),
+		Genre:       m.Genre(),
+		Composer:    m.Composer(),
+		Comment:     m.Comment(),
+		ImageURL:    artURL(m),
+		Tags:        rawTags(m),
 	}
-	return si, m, b, nil
+	return si, m, rs, sz, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
 }
 
-func dataURL(m tag.Metadata) string {
+func (nopCloser) Close() error { return nil }
+
+// rawTags returns the textual tags of m. Binary tags like pictures are
+// skipped.
+func rawTags(m tag.Metadata) map[string]string {
+	tags := make(map[string]string)
+	for k, v := range m.Raw() {
+		switch v := v.(type) {
+		case string:
+			tags[k] = v
+		case int:
+			tags[k] = strconv.Itoa(v)
+		case *tag.Comm:
+			tags[k] = v.Text
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// artURL stores the picture of m, if any, in the art cache and returns its
+// URL.
+func artURL(m tag.Metadata) string {
 	p := m.Picture()
 	if p == nil {
 		return ""
 	}
-	if p.MIMEType == "-->" {
-		return string(p.Data)
-	}
-	return fmt.Sprintf("data:%s;base64,%s", p.MIMEType, base64.StdEncoding.EncodeToString(p.Data))
+	return art.Put(p.MIMEType, p.Data)
 }
 
 // Decode decodes audio that has been encoded in a registered codec.
@@ -215,6 +270,13 @@ func extension(path string) (*codec, error) {
 	return c, nil
 }
 
+// HasExtension reports whether a codec is registered for the extension of
+// path.
+func HasExtension(path string) bool {
+	_, err := extension(path)
+	return err == nil
+}
+
 func ByExtension(path string, rf Reader) (Songs, string, error) {
 	c, err := extension(path)
 	if err != nil {
@@ -2,17 +2,17 @@ package flac
 
 import (
 	"bytes"
-	"encoding/base64"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mewkiz/flac"
 	"github.com/mewkiz/flac/frame"
 	"github.com/mewkiz/flac/meta"
+	"github.com/mjibson/moggio/art"
 	"github.com/mjibson/moggio/codec"
 )
 
@@ -73,35 +73,75 @@ func (f *Flac) Info() (info codec.SongInfo, err error) {
 		return
 	}
 	si := codec.SongInfo{
-		Time: time.Duration(fv.Info.NSamples) / time.Duration(fv.Info.SampleRate) * time.Second,
+		Time:       time.Duration(fv.Info.NSamples) / time.Duration(fv.Info.SampleRate) * time.Second,
+		SampleRate: int(fv.Info.SampleRate),
+		Channels:   int(fv.Info.NChannels),
+		BitDepth:   int(fv.Info.BitsPerSample),
 	}
 	for _, b := range fv.Blocks {
 		switch v := b.Body.(type) {
 		case *meta.VorbisComment:
 			for _, tag := range v.Tags {
-				switch tag[0] {
+				name, val := strings.ToUpper(tag[0]), tag[1]
+				if si.Tags == nil {
+					si.Tags = make(map[string]string)
+				}
+				si.Tags[name] = val
+				switch name {
 				case "TITLE":
-					si.Title = tag[1]
+					si.Title = val
 				case "ARTIST":
-					si.Artist = tag[1]
+					si.Artist = val
 				case "ALBUM":
-					si.Album = tag[1]
+					si.Album = val
+				case "ALBUMARTIST", "ALBUM ARTIST":
+					si.AlbumArtist = val
 				case "TRACKNUMBER":
-					n, _ := strconv.Atoi(tag[1])
+					n, total := number(val)
 					si.Track = float64(n)
+					if total != 0 {
+						si.TrackTotal = total
+					}
+				case "TRACKTOTAL", "TOTALTRACKS":
+					si.TrackTotal, _ = number(val)
+				case "DISCNUMBER":
+					n, total := number(val)
+					si.Disc = n
+					if total != 0 {
+						si.DiscTotal = total
+					}
+				case "DISCTOTAL", "TOTALDISCS":
+					si.DiscTotal, _ = number(val)
+				case "DATE", "YEAR":
+					// Dates are usually YYYY or YYYY-MM-DD.
+					if len(val) >= 4 {
+						si.Year, _ = strconv.Atoi(val[:4])
+					}
+				case "GENRE":
+					si.Genre = val
+				case "COMPOSER":
+					si.Composer = val
+				case "COMMENT", "DESCRIPTION":
+					si.Comment = val
 				}
 			}
 		case *meta.Picture:
-			if v.MIME == "-->" {
-				si.ImageURL = string(v.Data)
-				break
-			}
-			si.ImageURL = fmt.Sprintf("data:%s;base64,%s", v.MIME, base64.StdEncoding.EncodeToString(v.Data))
+			si.ImageURL = art.Put(v.MIME, v.Data)
 		}
 	}
 	return si, nil
 }
 
+// number parses a vorbis comment number of the form "n" or "n/total".
+func number(s string) (n, total int) {
+	sp := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	n, _ = strconv.Atoi(sp[0])
+	if len(sp) == 2 {
+		total, _ = strconv.Atoi(sp[1])
+	}
+	return
+}
+
 func (f *Flac) Play(n int) ([]float32, error) {
 	var err error
 	var frame *frame.Frame
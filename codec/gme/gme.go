@@ -74,14 +74,40 @@ func (t *Track) Info() (codec.SongInfo, error) {
 		return si, err
 	}
 	info, err := g.Track(t.track)
-	g.Close()
-	return codec.SongInfo{
-		Time:   info.PlayLength + gme.FadeLength,
-		Artist: info.Author,
-		Title:  info.Song,
-		Album:  info.Game,
-		Track:  float64(t.track),
-	}, err
+	defer g.Close()
+	si = codec.SongInfo{
+		Time:       info.PlayLength + gme.FadeLength,
+		Artist:     info.Author,
+		Title:      info.Song,
+		Album:      info.Game,
+		Track:      float64(t.track),
+		TrackTotal: g.Tracks(),
+		Comment:    info.Comment,
+		SampleRate: defaultSampleRate,
+		Channels:   defaultChannels,
+		BitDepth:   16,
+	}
+	tags := map[string]string{
+		"system":    info.System,
+		"copyright": info.Copyright,
+		"dumper":    info.Dumper,
+	}
+	for k, v := range tags {
+		if v == "" {
+			continue
+		}
+		if si.Tags == nil {
+			si.Tags = make(map[string]string)
+		}
+		si.Tags[k] = v
+	}
+	// Copyright is typically "1991 Nintendo".
+	if len(info.Copyright) >= 4 {
+		if y, err := strconv.Atoi(info.Copyright[:4]); err == nil {
+			si.Year = y
+		}
+	}
+	return si, err
 }
 
 func (t *Track) Init() (sampleRate, channels int, err error) {
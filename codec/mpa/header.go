@@ -0,0 +1,149 @@
+package mpa
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// header describes an MPEG audio stream as determined from its first frame.
+type header struct {
+	// start is the offset of the first frame.
+	start      int64
+	sampleRate int
+	channels   int
+	bitrate    int
+	// samples is the number of samples per frame.
+	samples int
+	// frames is the number of frames from a Xing or VBRI header, or 0.
+	frames int
+}
+
+var errNoFrame = errors.New("mpa: no frame found")
+
+var (
+	// Indexed by version (0: MPEG 1, 1: MPEG 2 and 2.5) and layer (0: Layer
+	// I, 1: Layer II, 2: Layer III).
+	bitrates = [2][3][15]int{
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+	}
+	// Indexed by the version bits of the header.
+	sampleRates = [4][3]int{
+		{11025, 12000, 8000},
+		{},
+		{22050, 24000, 16000},
+		{44100, 48000, 32000},
+	}
+)
+
+// readHeader skips any ID3v2 tag and parses the first frame of r. It only
+// reads the start of the file.
+func readHeader(r io.ReadSeeker) (*header, error) {
+	var h header
+	id3 := make([]byte, 10)
+	if _, err := io.ReadFull(r, id3); err != nil {
+		return nil, err
+	}
+	if string(id3[:3]) == "ID3" {
+		h.start = int64(id3[6])<<21 | int64(id3[7])<<14 | int64(id3[8])<<7 | int64(id3[9])
+		h.start += 10
+		// Footer present.
+		if id3[5]&0x10 != 0 {
+			h.start += 10
+		}
+	}
+	if _, err := r.Seek(h.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8192)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xff || buf[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := int(buf[i+1]>>3) & 3
+		layer := 3 - int(buf[i+1]>>1)&3
+		bitrateIdx := int(buf[i+2] >> 4)
+		srIdx := int(buf[i+2]>>2) & 3
+		if version == 1 || layer == 3 || bitrateIdx == 0 || bitrateIdx == 15 || srIdx == 3 {
+			continue
+		}
+		h.start += int64(i)
+		v := 0
+		if version != 3 {
+			v = 1
+		}
+		h.bitrate = bitrates[v][layer][bitrateIdx] * 1000
+		h.sampleRate = sampleRates[version][srIdx]
+		mono := buf[i+3]>>6 == 3
+		h.channels = 2
+		if mono {
+			h.channels = 1
+		}
+		switch {
+		case layer == 0:
+			h.samples = 384
+		case layer == 2 && v == 1:
+			h.samples = 576
+		default:
+			h.samples = 1152
+		}
+		h.frames = vbrFrames(buf[i:], v, mono)
+		return &h, nil
+	}
+	return nil, errNoFrame
+}
+
+// vbrFrames returns the frame count from a Xing, Info or VBRI header in
+// frame, or 0 if there is none.
+func vbrFrames(frame []byte, version int, mono bool) int {
+	// The Xing header follows the side information.
+	off := 36
+	switch {
+	case version == 0 && mono:
+		off = 21
+	case version == 1 && mono:
+		off = 13
+	case version == 1:
+		off = 21
+	}
+	if len(frame) >= off+12 {
+		switch string(frame[off : off+4]) {
+		case "Xing", "Info":
+			flags := binary.BigEndian.Uint32(frame[off+4:])
+			if flags&1 != 0 {
+				return int(binary.BigEndian.Uint32(frame[off+8:]))
+			}
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(frame[36+14:]))
+	}
+	return 0
+}
+
+// duration returns the duration of a stream of size bytes. It is exact for
+// files with a VBR header and estimated from the bitrate otherwise.
+func (h *header) duration(size int64) time.Duration {
+	if h.frames > 0 && h.sampleRate > 0 {
+		return time.Duration(h.frames) * time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate)
+	}
+	if h.bitrate == 0 || size <= h.start {
+		return 0
+	}
+	return time.Duration(size-h.start) * 8 * time.Second / time.Duration(h.bitrate)
+}
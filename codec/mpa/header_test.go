@@ -0,0 +1,125 @@
+package mpa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// frame returns a frame of n bytes starting with the 4 header bytes h,
+// with tag and count written at off if tag is set.
+func frame(h []byte, n, off int, tag string, count uint32) []byte {
+	b := make([]byte, n)
+	copy(b, h)
+	if tag != "" {
+		copy(b[off:], tag)
+		if tag == "VBRI" {
+			binary.BigEndian.PutUint32(b[off+14:], count)
+		} else {
+			binary.BigEndian.PutUint32(b[off+4:], 1)
+			binary.BigEndian.PutUint32(b[off+8:], count)
+		}
+	}
+	return b
+}
+
+func id3(size int) []byte {
+	b := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 0}
+	b[6] = byte(size >> 21 & 0x7f)
+	b[7] = byte(size >> 14 & 0x7f)
+	b[8] = byte(size >> 7 & 0x7f)
+	b[9] = byte(size & 0x7f)
+	return append(b, make([]byte, size)...)
+}
+
+func TestReadHeader(t *testing.T) {
+	// MPEG 1 Layer III, 128 kbit/s, 44.1 kHz.
+	stereo := []byte{0xff, 0xfb, 0x90, 0x00}
+	mono := []byte{0xff, 0xfb, 0x90, 0xc0}
+	// MPEG 2 Layer III, 64 kbit/s, 22.05 kHz, mono.
+	mpeg2 := []byte{0xff, 0xf3, 0x80, 0xc0}
+	tests := []struct {
+		name string
+		data []byte
+		// size is the file size used for the duration.
+		size int64
+		want header
+		dur  time.Duration
+	}{
+		{
+			name: "cbr",
+			data: frame(stereo, 417, 0, "", 0),
+			size: 160000,
+			want: header{sampleRate: 44100, channels: 2, bitrate: 128000, samples: 1152},
+			dur:  10 * time.Second,
+		},
+		{
+			name: "cbr after id3",
+			data: append(id3(100), frame(stereo, 417, 0, "", 0)...),
+			size: 110 + 160000,
+			want: header{start: 110, sampleRate: 44100, channels: 2, bitrate: 128000, samples: 1152},
+			dur:  10 * time.Second,
+		},
+		{
+			name: "cbr after junk",
+			data: append([]byte{0xff, 0xfb, 0xf0, 0, 1, 2}, frame(stereo, 417, 0, "", 0)...),
+			size: 6 + 160000,
+			want: header{start: 6, sampleRate: 44100, channels: 2, bitrate: 128000, samples: 1152},
+			dur:  10 * time.Second,
+		},
+		{
+			name: "xing",
+			data: frame(stereo, 417, 36, "Xing", 441),
+			size: 1e6,
+			want: header{sampleRate: 44100, channels: 2, bitrate: 128000, samples: 1152, frames: 441},
+			dur:  441 * 1152 * time.Second / 44100,
+		},
+		{
+			name: "info mono",
+			data: frame(mono, 417, 21, "Info", 1000),
+			size: 1e6,
+			want: header{sampleRate: 44100, channels: 1, bitrate: 128000, samples: 1152, frames: 1000},
+			dur:  1000 * 1152 * time.Second / 44100,
+		},
+		{
+			name: "xing mpeg 2 mono",
+			data: frame(mpeg2, 208, 13, "Xing", 500),
+			size: 1e6,
+			want: header{sampleRate: 22050, channels: 1, bitrate: 64000, samples: 576, frames: 500},
+			dur:  500 * 576 * time.Second / 22050,
+		},
+		{
+			name: "vbri",
+			data: frame(stereo, 417, 36, "VBRI", 2000),
+			size: 1e6,
+			want: header{sampleRate: 44100, channels: 2, bitrate: 128000, samples: 1152, frames: 2000},
+			dur:  2000 * 1152 * time.Second / 44100,
+		},
+	}
+	for _, test := range tests {
+		h, err := readHeader(bytes.NewReader(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *h != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, *h, test.want)
+		}
+		if d := h.duration(test.size); d != test.dur {
+			t.Errorf("%s: duration %v, want %v", test.name, d, test.dur)
+		}
+	}
+}
+
+func TestReadHeaderNoFrame(t *testing.T) {
+	for _, data := range [][]byte{
+		make([]byte, 100),
+		// Reserved version, then a free bitrate.
+		{0xff, 0xeb, 0x90, 0x00, 0xff, 0xfb, 0x00, 0x00, 0, 0, 0, 0},
+	} {
+		if _, err := readHeader(bytes.NewReader(data)); err != errNoFrame {
+			t.Errorf("%x: got %v, want %v", data, err, errNoFrame)
+		}
+	}
+}
@@ -1,14 +1,10 @@
 package mpa
 
 import (
-	"bytes"
 	"io"
-	"math"
-	"time"
 
 	"github.com/dhowden/tag"
 	"github.com/korandiz/mpa"
-	"github.com/korandiz/mpseek"
 	"github.com/mjibson/moggio/codec"
 )
 
@@ -60,15 +56,19 @@ func (s *Song) Info() (info codec.SongInfo, err error) {
 	if s.info != nil {
 		return *s.info, nil
 	}
-	si, _, b, err := s.Reader.Metadata(tag.MP3)
+	si, _, r, size, err := s.Reader.Metadata(tag.MP3)
 	if err != nil {
 		return
 	}
-	table, err := mpseek.CreateTable(bytes.NewReader(b), math.MaxFloat64)
+	defer r.Close()
+	h, err := readHeader(r)
 	if err != nil {
 		return
 	}
-	si.Time = time.Duration(table.Length()) * time.Second
+	si.Time = h.duration(size)
+	si.SampleRate = h.sampleRate
+	si.Channels = h.channels
+	si.Bitrate = h.bitrate
 	s.info = si
 	return *si, nil
 }
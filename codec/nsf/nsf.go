@@ -100,11 +100,14 @@ func (n *NSFSong) Info() (si codec.SongInfo, err error) {
 		title = fmt.Sprintf("%s:%02d", n.NSF.Game, n.Index)
 	}
 	si = codec.SongInfo{
-		Time:   s.Duration,
-		Artist: n.NSF.Artist,
-		Album:  n.NSF.Game,
-		Track:  float64(n.Index),
-		Title:  title,
+		Time:       s.Duration,
+		Artist:     n.NSF.Artist,
+		Album:      n.NSF.Game,
+		Track:      float64(n.Index),
+		TrackTotal: len(n.NSF.Songs),
+		Title:      title,
+		SampleRate: int(n.NSF.SampleRate),
+		Channels:   1,
 	}
 	return
 }
@@ -77,9 +77,9 @@ func Read(rf codec.Reader) (codec.Songs, error) {
 	f := func(d *rardecode.Reader, fh *rardecode.FileHeader) (stop bool) {
 		rd = d
 		rfh = fh
-		ss, _, _ := codec.ByExtension(fh.Name, readRAR(fh.Name, rf))
+		ss, name, _ := codec.ByExtension(fh.Name, readRAR(fh.Name, rf))
 		for v, s := range ss {
-			songs[codec.NewID(fh.Name, string(v))] = s
+			songs[codec.NewID(fh.Name, string(v))] = &song{s, name}
 		}
 		return false
 	}
@@ -89,6 +89,20 @@ func Read(rf codec.Reader) (codec.Songs, error) {
 	return songs, nil
 }
 
+// song reports the codec of the archived file instead of RAR.
+type song struct {
+	codec.Song
+	codec string
+}
+
+func (s *song) Info() (codec.SongInfo, error) {
+	si, err := s.Song.Info()
+	if si.Codec == "" {
+		si.Codec = s.codec
+	}
+	return si, err
+}
+
 type id struct {
 	name string
 	v    interface{}
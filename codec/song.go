@@ -1,6 +1,9 @@
 package codec
 
-import "time"
+import (
+	"reflect"
+	"time"
+)
 
 type Song interface {
 	// Info returns information about a song.
@@ -17,14 +20,50 @@ type Song interface {
 }
 
 type SongInfo struct {
-	Time     time.Duration
-	Artist   string
-	Title    string
-	Album    string
-	Track    float64
+	Time        time.Duration
+	Artist      string
+	Title       string
+	Album       string
+	AlbumArtist string `json:",omitempty"`
+	Track       float64
+	TrackTotal  int    `json:",omitempty"`
+	Disc        int    `json:",omitempty"`
+	DiscTotal   int    `json:",omitempty"`
+	Year        int    `json:",omitempty"`
+	Genre       string `json:",omitempty"`
+	Composer    string `json:",omitempty"`
+	Comment     string `json:",omitempty"`
+	// ImageURL is the URL of the cover art, usually served by the art
+	// package.
 	ImageURL string `json:",omitempty"`
 
+	// Tags holds all textual tags found in the file, keyed by their name in
+	// the tag format (e.g., "TPE1" or "ARTIST").
+	Tags map[string]string `json:",omitempty"`
+
+	// Technical properties of the encoded stream. Bitrate is in bits per
+	// second; BitDepth is only set for lossless formats.
+	Codec      string `json:",omitempty"`
+	Bitrate    int    `json:",omitempty"`
+	SampleRate int    `json:",omitempty"`
+	Channels   int    `json:",omitempty"`
+	BitDepth   int    `json:",omitempty"`
+	FileSize   int64  `json:",omitempty"`
+
 	// SongTitle, if set, is the currently playing song title. Needed for
 	// streaming.
 	SongTitle string
 }
+
+// Equal reports whether si and o describe the same song info.
+func (si *SongInfo) Equal(o *SongInfo) bool {
+	return reflect.DeepEqual(si, o)
+}
+
+// Less reports whether si is ordered before o within an album.
+func (si *SongInfo) Less(o *SongInfo) bool {
+	if si.Disc != o.Disc {
+		return si.Disc < o.Disc
+	}
+	return si.Track < o.Track
+}
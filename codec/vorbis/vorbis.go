@@ -1,7 +1,6 @@
 package vorbis
 
 import (
-	"bytes"
 	"io"
 	"time"
 
@@ -59,11 +58,12 @@ func (v *Vorbis) Info() (info codec.SongInfo, err error) {
 	if v.info != nil {
 		return *v.info, nil
 	}
-	si, _, b, err := v.Reader.Metadata(tag.OGG)
+	si, _, r, _, err := v.Reader.Metadata(tag.OGG)
 	if err != nil {
 		return
 	}
-	or := ogg.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	or := ogg.NewReader(r)
 	vr, err := vorbis.OpenOgg(or)
 	if err != nil {
 		return
@@ -73,6 +73,9 @@ func (v *Vorbis) Info() (info codec.SongInfo, err error) {
 		return
 	}
 	si.Time = time.Duration(l/uint64(vr.SampleRate())) * time.Second
+	si.SampleRate = vr.SampleRate()
+	si.Channels = vr.Channels()
+	_, si.Bitrate, _ = vr.Bitrate()
 	v.info = si
 	return *si, nil
 }
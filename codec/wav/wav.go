@@ -65,7 +65,11 @@ func (w *Wav) Info() (info codec.SongInfo, err error) {
 		return
 	}
 	return codec.SongInfo{
-		Time: wv.Duration,
+		Time:       wv.Duration,
+		Bitrate:    int(wv.ByteRate) * 8,
+		SampleRate: int(wv.SampleRate),
+		Channels:   int(wv.NumChannels),
+		BitDepth:   int(wv.BitsPerSample),
 	}, nil
 }
 
@@ -0,0 +1,500 @@
+// Package ctl implements "moggio ctl", a command line client of a running
+// moggio server's HTTP API.
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `usage: moggio ctl [-json] [-server url] command [args]
+
+Playback:
+	status                      show the playing song
+	play [index]                play, or play the song at index of the queue
+	pause                       pause or resume
+	stop, next, prev            stop, or play the next or previous song
+	seek position               seek to a position like 90 or 1m30s
+	random, repeat, repeat-one  toggle playback options
+	watch                       print the status as it changes
+
+Queue:
+	queue [list]                list the queue
+	queue add query             add the songs matching a search query
+	queue add-playlist name     add the songs of a playlist
+	queue rm index...           remove songs
+	queue move from to          move a song before index to
+	queue clear, queue shuffle  remove or shuffle all songs
+
+Playlists:
+	playlist [list]             list the playlists
+	playlist show name          list the songs of a playlist
+	playlist add name query     add the songs matching a search query
+	playlist rm name index...   remove songs
+	playlist delete name        delete a playlist
+	playlist import name file   replace a playlist with a playlist file
+	playlist export name [fmt]  write a playlist as m3u8, pls or xspf
+
+Library:
+	search query                list the songs matching a search query
+	protocol [list]             list the protocol instances
+	protocol add name params... add a protocol instance
+	protocol refresh name key   rescan a protocol instance
+	protocol remove name key    remove a protocol instance
+`
+
+// Main runs the ctl command with args. server is the default URL of the
+// server.
+func Main(server string, args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+	}
+	c := &Client{Out: os.Stdout}
+	fs.StringVar(&c.URL, "server", server, "server URL")
+	fs.BoolVar(&c.JSON, "json", false, "print JSON responses")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no command")
+	}
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	return c.Run(fs.Args())
+}
+
+// Client runs commands against the server at URL.
+type Client struct {
+	URL string
+	// JSON prints responses as JSON instead of text.
+	JSON bool
+	Out  io.Writer
+}
+
+// Run runs the command in args.
+func (c *Client) Run(args []string) error {
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "status":
+		return c.status()
+	case "play":
+		if len(args) > 0 {
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("bad index: %s", args[0])
+			}
+			return c.cmd("play_idx", url.Values{"idx": {args[0]}})
+		}
+		return c.cmd("play", nil)
+	case "pause", "stop", "next", "prev", "random", "repeat":
+		return c.cmd(cmd, nil)
+	case "repeat-one":
+		return c.cmd("repeat_one", nil)
+	case "seek":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: seek position")
+		}
+		pos := args[0]
+		if _, err := strconv.ParseFloat(pos, 64); err == nil {
+			pos += "s"
+		}
+		if _, err := time.ParseDuration(pos); err != nil {
+			return fmt.Errorf("bad position: %s", args[0])
+		}
+		return c.cmd("seek", url.Values{"pos": {pos}})
+	case "watch":
+		return c.watch()
+	case "queue":
+		return c.queue(args)
+	case "playlist":
+		return c.playlist(args)
+	case "search":
+		return c.search(strings.Join(args, " "))
+	case "protocol":
+		return c.protocol(args)
+	}
+	return fmt.Errorf("unknown command: %s", cmd)
+}
+
+// do makes a request to the API at path and returns the response body. body,
+// if not nil, is sent as JSON unless it is an io.Reader.
+func (c *Client) do(method, path string, form url.Values, body interface{}) ([]byte, error) {
+	u := c.URL + "/api/" + path
+	if len(form) > 0 {
+		u += "?" + form.Encode()
+	}
+	var r io.Reader
+	if br, ok := body.(io.Reader); ok {
+		r = br
+	} else if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := strings.TrimSpace(string(b))
+		if msg == "" {
+			msg = resp.Status
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return b, nil
+}
+
+// get gets the API at path and decodes its JSON response into v.
+func (c *Client) get(path string, form url.Values, v interface{}) error {
+	b, err := c.do("GET", path, form, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// print prints b as indented JSON.
+func (c *Client) print(b []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "\t"); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := c.Out.Write(buf.Bytes())
+	return err
+}
+
+func (c *Client) cmd(name string, form url.Values) error {
+	b, err := c.do("POST", "cmd/"+name, form, nil)
+	if err != nil || !c.JSON || len(b) == 0 {
+		return err
+	}
+	return c.print(b)
+}
+
+func (c *Client) status() error {
+	b, err := c.do("GET", "cmd/status", nil, nil)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		return c.print(b)
+	}
+	var st Status
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Out, st)
+	return nil
+}
+
+// playlists returns the queue and playlists.
+func (c *Client) playlists() (*Playlists, error) {
+	var wd struct {
+		Data Playlists
+	}
+	if err := c.get("data/playlist", nil, &wd); err != nil {
+		return nil, err
+	}
+	return &wd.Data, nil
+}
+
+// searchAll returns all songs matching query. If info is set, they include
+// their info.
+func (c *Client) searchAll(query string, info bool) ([]Item, error) {
+	var items []Item
+	for {
+		var res SearchResult
+		form := url.Values{
+			"q":      {query},
+			"offset": {strconv.Itoa(len(items))},
+			"limit":  {"1000"},
+		}
+		if info {
+			form.Set("info", "1")
+		}
+		if err := c.get("search", form, &res); err != nil {
+			return nil, err
+		}
+		if info {
+			items = append(items, res.Items...)
+		} else {
+			for _, id := range res.Songs {
+				items = append(items, Item{ID: id})
+			}
+		}
+		if len(res.Songs) == 0 || len(items) >= res.Total {
+			return items, nil
+		}
+	}
+}
+
+func (c *Client) search(query string) error {
+	if query == "" {
+		return fmt.Errorf("usage: search query")
+	}
+	items, err := c.searchAll(query, true)
+	if err != nil {
+		return err
+	}
+	return c.list(items)
+}
+
+// list prints the songs of items.
+func (c *Client) list(items []Item) error {
+	if c.JSON {
+		b, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		return c.print(b)
+	}
+	for i, item := range items {
+		fmt.Fprintf(c.Out, "%4d  %s\n", i, item)
+	}
+	return nil
+}
+
+// change applies plc to the queue, or if name is not empty, the playlist
+// name.
+func (c *Client) change(name string, plc [][]string) error {
+	path := "queue/change"
+	if name != "" {
+		path = "playlist/change/" + url.PathEscape(name)
+	}
+	_, err := c.do("POST", path, nil, plc)
+	return err
+}
+
+// edit runs the queue or playlist commands in args, which start with the
+// subcommand.
+func (c *Client) edit(name string, args []string) error {
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "add":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: add query")
+		}
+		items, err := c.searchAll(strings.Join(args, " "), false)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("no songs found")
+		}
+		plc := make([][]string, len(items))
+		for i, item := range items {
+			plc[i] = []string{"add", item.ID.UID}
+		}
+		return c.change(name, plc)
+	case "add-playlist":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: add-playlist name")
+		}
+		return c.change(name, [][]string{{"add-playlist", args[0]}})
+	case "rm":
+		if len(args) == 0 {
+			return fmt.Errorf("usage: rm index...")
+		}
+		var plc [][]string
+		for _, s := range args {
+			if _, err := strconv.Atoi(s); err != nil {
+				return fmt.Errorf("bad index: %s", s)
+			}
+			plc = append(plc, []string{"rem", s})
+		}
+		return c.change(name, plc)
+	case "move":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: move from to")
+		}
+		return c.change(name, [][]string{{"move", args[0], args[1]}})
+	case "clear", "shuffle":
+		return c.change(name, [][]string{{sub}})
+	}
+	return fmt.Errorf("unknown command: %s", sub)
+}
+
+func (c *Client) queue(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		p, err := c.playlists()
+		if err != nil {
+			return err
+		}
+		return c.list(p.Queue)
+	}
+	return c.edit("", args)
+}
+
+func (c *Client) playlist(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		p, err := c.playlists()
+		if err != nil {
+			return err
+		}
+		var names []string
+		for name := range p.Playlists {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if c.JSON {
+			b, err := json.Marshal(names)
+			if err != nil {
+				return err
+			}
+			return c.print(b)
+		}
+		for _, name := range names {
+			kind := ""
+			if _, ok := p.Smart[name]; ok {
+				kind = ", smart"
+			}
+			fmt.Fprintf(c.Out, "%s (%d songs%s)\n", name, len(p.Playlists[name]), kind)
+		}
+		return nil
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: playlist %s name", args[0])
+	}
+	sub, name := args[0], args[1]
+	switch sub {
+	case "show":
+		p, err := c.playlists()
+		if err != nil {
+			return err
+		}
+		items, ok := p.Playlists[name]
+		if !ok {
+			return fmt.Errorf("unknown playlist: %s", name)
+		}
+		return c.list(items)
+	case "delete":
+		return c.change(name, [][]string{{"clear"}})
+	case "import":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: playlist import name file")
+		}
+		f, err := os.Open(args[2])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		form := url.Values{"format": {filepath.Base(args[2])}}
+		b, err := c.do("POST", "playlist/import/"+url.PathEscape(name), form, f)
+		if err != nil {
+			return err
+		}
+		if c.JSON {
+			return c.print(b)
+		}
+		var res struct {
+			Songs     int
+			Unmatched []string
+		}
+		if err := json.Unmarshal(b, &res); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Out, "imported %d songs\n", res.Songs)
+		for _, s := range res.Unmatched {
+			fmt.Fprintf(c.Out, "not found: %s\n", s)
+		}
+		return nil
+	case "export":
+		var form url.Values
+		if len(args) > 2 {
+			form = url.Values{"format": {args[2]}}
+		}
+		b, err := c.do("GET", "playlist/export/"+url.PathEscape(name), form, nil)
+		if err != nil {
+			return err
+		}
+		_, err = c.Out.Write(b)
+		return err
+	}
+	return c.edit(name, append([]string{sub}, args[2:]...))
+}
+
+func (c *Client) protocol(args []string) error {
+	if len(args) == 0 || args[0] == "list" {
+		b, err := c.do("GET", "data/protocols", nil, nil)
+		if err != nil {
+			return err
+		}
+		var wd struct {
+			Data struct {
+				Current    map[string][]string
+				InProgress map[string]bool
+			}
+		}
+		if err := json.Unmarshal(b, &wd); err != nil {
+			return err
+		}
+		if c.JSON {
+			b, err := json.Marshal(wd.Data.Current)
+			if err != nil {
+				return err
+			}
+			return c.print(b)
+		}
+		var names []string
+		for name := range wd.Data.Current {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			keys := wd.Data.Current[name]
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(c.Out, "%s\t%s\n", name, key)
+			}
+		}
+		return nil
+	}
+	switch sub := args[0]; sub {
+	case "add":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: protocol add name params...")
+		}
+		_, err := c.do("POST", "protocol/add", nil, struct {
+			Protocol string
+			Params   []string
+		}{args[1], args[2:]})
+		return err
+	case "refresh", "remove":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: protocol %s name key", sub)
+		}
+		_, err := c.do("POST", "protocol/"+sub, nil, struct {
+			Protocol string
+			Key      string
+		}{args[1], args[2]})
+		return err
+	default:
+		return fmt.Errorf("unknown command: %s", sub)
+	}
+}
@@ -0,0 +1,133 @@
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/server"
+)
+
+// SongID is a song ID as sent by the server. UID is the ID to send back.
+type SongID struct {
+	Protocol string
+	Key      string
+	ID       string
+	UID      string
+}
+
+// UnmarshalJSON decodes id, which the server sends as "" if there is no
+// song.
+func (id *SongID) UnmarshalJSON(b []byte) error {
+	if string(b) == `""` {
+		*id = SongID{}
+		return nil
+	}
+	type songID SongID
+	return json.Unmarshal(b, (*songID)(id))
+}
+
+// Item is a song of a list.
+type Item struct {
+	ID    SongID
+	Info  *codec.SongInfo
+	Stats json.RawMessage `json:",omitempty"`
+}
+
+func (it Item) String() string {
+	si := it.Info
+	if si == nil || si.Title == "" {
+		return it.ID.Protocol + " " + oneLine(it.ID.ID)
+	}
+	s := si.Title
+	if si.Artist != "" {
+		s = si.Artist + " - " + s
+	}
+	if si.Album != "" {
+		s += " (" + si.Album + ")"
+	}
+	if si.Time > 0 {
+		s += " [" + duration(si.Time) + "]"
+	}
+	return s
+}
+
+// Status is the playback status.
+type Status struct {
+	State        server.State
+	Song         SongID
+	SongInfo     codec.SongInfo
+	Elapsed      time.Duration
+	Time         time.Duration
+	Random       bool
+	Repeat       bool
+	RepeatOne    bool
+	AlbumShuffle bool
+	AutoDJ       bool
+	Volume       float64
+}
+
+func (st Status) String() string {
+	s := st.State.String() + ": "
+	if st.Song.UID == "" {
+		s += "no song"
+	} else {
+		info := st.SongInfo
+		if info.SongTitle != "" {
+			info.Title = info.SongTitle
+		}
+		s += Item{ID: st.Song, Info: &info}.String()
+		s += " " + duration(st.Elapsed)
+		if st.Time > 0 {
+			s += "/" + duration(st.Time)
+		}
+	}
+	for _, o := range []struct {
+		on   bool
+		name string
+	}{
+		{st.Random, "random"},
+		{st.AlbumShuffle, "album-shuffle"},
+		{st.Repeat, "repeat"},
+		{st.RepeatOne, "repeat-one"},
+		{st.AutoDJ, "auto-dj"},
+	} {
+		if o.on {
+			s += " " + o.name
+		}
+	}
+	return s
+}
+
+// Playlists are the queue and playlists.
+type Playlists struct {
+	Queue     []Item
+	Playlists map[string][]Item
+	// Smart holds the rules of the smart playlists.
+	Smart   map[string]json.RawMessage
+	Version uint64
+}
+
+// SearchResult is a page of search results.
+type SearchResult struct {
+	Total  int
+	Offset int
+	Songs  []SongID
+	Items  []Item
+}
+
+// duration formats d like 3:05 or 1:02:03.
+func duration(d time.Duration) string {
+	s := int(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// oneLine replaces the newlines in s with spaces.
+func oneLine(s string) string {
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,65 @@
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// watch prints the status each time the server sends it over the websocket,
+// as a line of JSON with c.JSON. Repeated identical lines are skipped. Errors
+// are printed to stderr.
+func (c *Client) watch() error {
+	u := "ws" + strings.TrimPrefix(c.URL, "http") + "/ws/?delta=1"
+	ws, err := websocket.Dial(u, "", c.URL+"/")
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+	var last string
+	for {
+		var wd struct {
+			Type string
+			Data json.RawMessage
+		}
+		if err := websocket.JSON.Receive(ws, &wd); err != nil {
+			return err
+		}
+		switch wd.Type {
+		case "status":
+			var line string
+			if c.JSON {
+				var buf bytes.Buffer
+				if err := json.Compact(&buf, wd.Data); err != nil {
+					return err
+				}
+				line = buf.String()
+			} else {
+				var st Status
+				if err := json.Unmarshal(wd.Data, &st); err != nil {
+					return err
+				}
+				line = st.String()
+			}
+			if line == last {
+				continue
+			}
+			last = line
+			if _, err := fmt.Fprintln(c.Out, line); err != nil {
+				return err
+			}
+		case "error":
+			var e struct {
+				Error string
+			}
+			if err := json.Unmarshal(wd.Data, &e); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, "error:", e.Error)
+		}
+	}
+}
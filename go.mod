@@ -9,12 +9,13 @@ require (
 	github.com/bradfitz/slice v0.0.0-20180809154707-2b758aa73013
 	github.com/dhowden/tag v0.0.0-20220618230019-adf36e896086
 	github.com/facebookgo/httpcontrol v0.0.0-20150708234001-ccde4420e1fe
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/helinwang/portaudio v0.0.0-20160225001950-035e99fec7e0
 	github.com/jfreymuth/go-vorbis v0.0.0-20161124120736-41342c908855
 	github.com/jfreymuth/pulse v0.1.0
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/korandiz/mpa v1.0.0
-	github.com/korandiz/mpseek v1.0.0
 	github.com/mewkiz/flac v1.0.7
 	github.com/mjibson/gme v0.0.0-20191207231606-aac85c97dedc
 	github.com/mjibson/go-dsp v0.0.0-20180508042940-11479a337f12
@@ -22,6 +23,7 @@ require (
 	github.com/nwaples/rardecode v1.1.3
 	github.com/oov/directsound-go v0.0.0-20141101201356-e53e59c700bf
 	github.com/pkg/browser v0.0.0-20210911075715-681adbf594b8
+	golang.org/x/image v0.0.0-20220722155232-062f8c9fd539
 	golang.org/x/net v0.0.0-20220811182439-13a9a731de15
 	golang.org/x/oauth2 v0.0.0-20220808172628-8227340efae7
 )
@@ -34,7 +36,7 @@ require (
 	github.com/mewkiz/pkg v0.0.0-20211102230744-16a6ce8f1b77 // indirect
 	github.com/mjibson/mog v0.0.0-00010101000000-000000000000 // indirect
 	go4.org v0.0.0-20201209231011-d4a079459e60 // indirect
-	golang.org/x/sys v0.0.0-20220811171246-fbc7d0a398ab // indirect
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 )
@@ -0,0 +1,141 @@
+package library
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// Auto-DJ strategies.
+const (
+	// DJSimilar prefers songs by the same artist or of the same genre as
+	// the last song.
+	DJSimilar = "similar"
+	// DJLeastRecent prefers songs played least recently, or never.
+	DJLeastRecent = "leastrecent"
+	// DJRated prefers songs with higher ratings and favorites.
+	DJRated = "rated"
+)
+
+// DJ configures how Pick chooses songs.
+type DJ struct {
+	// Strategy is DJSimilar, DJLeastRecent or DJRated. If empty, songs are
+	// chosen at random.
+	Strategy string `json:",omitempty"`
+	// Avoid excludes songs played more recently than this.
+	Avoid time.Duration `json:",omitempty"`
+	// MaxPerArtist, if not 0, is the most songs by an artist allowed in the
+	// recent songs and the picked songs together.
+	MaxPerArtist int `json:",omitempty"`
+}
+
+// Check verifies the fields of dj.
+func (dj DJ) Check() error {
+	switch dj.Strategy {
+	case "", DJSimilar, DJLeastRecent, DJRated:
+	default:
+		return fmt.Errorf("unknown strategy: %s", dj.Strategy)
+	}
+	if dj.Avoid < 0 || dj.MaxPerArtist < 0 {
+		return fmt.Errorf("avoid and max per artist must not be negative")
+	}
+	return nil
+}
+
+// leastRecentPool is the number of least recently played songs DJLeastRecent
+// picks from, so that it doesn't always pick the same songs.
+const leastRecentPool = 50
+
+// Pick returns up to n songs chosen by dj. recent are the songs played or
+// queued recently, most recent last; they are not picked, and the last one
+// is the song DJSimilar is similar to.
+func (x *Index) Pick(dj DJ, recent []codec.ID, n int) []codec.ID {
+	exclude := make(map[codec.ID]bool, len(recent))
+	artists := make(map[string]int)
+	for _, id := range recent {
+		exclude[id] = true
+		if d := x.docs[id]; d != nil {
+			artists[strings.ToLower(d.Info.Artist)]++
+		}
+	}
+	var last *Doc
+	if len(recent) > 0 {
+		last = x.docs[recent[len(recent)-1]]
+	}
+	now := time.Now()
+	var docs []*Doc
+	for id, d := range x.docs {
+		if exclude[id] {
+			continue
+		}
+		if dj.Avoid > 0 && now.Sub(d.Stats.LastPlayed) < dj.Avoid {
+			continue
+		}
+		docs = append(docs, d)
+	}
+	// Sort first so that picks only depend on the random source.
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+	weight := func(d *Doc) float64 { return 1 }
+	switch dj.Strategy {
+	case DJSimilar:
+		if last != nil {
+			weight = func(d *Doc) float64 {
+				w := 0.05
+				if last.Info.Artist != "" && strings.EqualFold(d.Info.Artist, last.Info.Artist) {
+					w += 2
+				}
+				if last.Info.Genre != "" && strings.EqualFold(d.Info.Genre, last.Info.Genre) {
+					w += 1
+				}
+				return w
+			}
+		}
+	case DJLeastRecent:
+		rand.Shuffle(len(docs), func(i, j int) { docs[i], docs[j] = docs[j], docs[i] })
+		sort.SliceStable(docs, func(i, j int) bool {
+			return docs[i].Stats.LastPlayed.Before(docs[j].Stats.LastPlayed)
+		})
+		if len(docs) > leastRecentPool+n {
+			docs = docs[:leastRecentPool+n]
+		}
+	case DJRated:
+		weight = func(d *Doc) float64 {
+			w := d.Stats.Rating + 0.5
+			if d.Stats.Rating == 0 {
+				// Treat unrated songs as average.
+				w = 3
+			}
+			if d.Stats.Favorite {
+				w *= 2
+			}
+			return w
+		}
+	}
+	var picked []codec.ID
+	for len(picked) < n && len(docs) > 0 {
+		var total float64
+		for _, d := range docs {
+			total += weight(d)
+		}
+		r := rand.Float64() * total
+		i := 0
+		for ; i < len(docs)-1; i++ {
+			if r -= weight(docs[i]); r < 0 {
+				break
+			}
+		}
+		d := docs[i]
+		docs = append(docs[:i], docs[i+1:]...)
+		artist := strings.ToLower(d.Info.Artist)
+		if dj.MaxPerArtist > 0 && artists[artist] >= dj.MaxPerArtist {
+			continue
+		}
+		artists[artist]++
+		picked = append(picked, d.ID)
+	}
+	return picked
+}
@@ -0,0 +1,228 @@
+package library
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// An Artist is an album artist, or the artist of songs without one.
+type Artist struct {
+	Name   string
+	Albums int
+	Tracks int
+}
+
+// An Album is the songs with the same album name and artist.
+type Album struct {
+	Name     string
+	Artist   string
+	Year     int    `json:",omitempty"`
+	ImageURL string `json:",omitempty"`
+	Tracks   int
+	Time     time.Duration
+	// IDs are the songs of the album ordered by disc and track. They are
+	// only set by Album.
+	IDs []codec.ID `json:",omitempty"`
+}
+
+type Genre struct {
+	Name   string
+	Tracks int
+}
+
+// A Folder is a directory of an instance whose song IDs are file paths.
+type Folder struct {
+	Path    string
+	Folders []Subfolder
+	// IDs are the songs directly in the folder, ordered by path.
+	IDs []codec.ID
+}
+
+type Subfolder struct {
+	Name string
+	// Tracks is the number of songs in the folder and its subfolders.
+	Tracks int
+}
+
+// aggregate holds the browse views. It is rebuilt after the index changes.
+type aggregate struct {
+	artists []Artist
+	albums  []*Album
+	genres  []Genre
+}
+
+type albumKey struct {
+	artist, name string
+}
+
+func (x *Index) aggregate() *aggregate {
+	if x.agg != nil {
+		return x.agg
+	}
+	albums := make(map[albumKey]*Album)
+	docs := make(map[albumKey][]*Doc)
+	genres := make(map[string]int)
+	for _, d := range x.docs {
+		si := d.Info
+		k := albumKey{albumArtist(si), si.Album}
+		a := albums[k]
+		if a == nil {
+			a = &Album{
+				Name:   k.name,
+				Artist: k.artist,
+			}
+			albums[k] = a
+		}
+		a.Tracks++
+		a.Time += si.Time
+		if si.Year > a.Year {
+			a.Year = si.Year
+		}
+		if a.ImageURL == "" {
+			a.ImageURL = si.ImageURL
+		}
+		docs[k] = append(docs[k], d)
+		if si.Genre != "" {
+			genres[si.Genre]++
+		}
+	}
+	agg := new(aggregate)
+	artists := make(map[string]*Artist)
+	for k, a := range albums {
+		ds := docs[k]
+		sort.Slice(ds, func(i, j int) bool {
+			return ds[i].Less(ds[j])
+		})
+		a.IDs = make([]codec.ID, len(ds))
+		for i, d := range ds {
+			a.IDs[i] = d.ID
+		}
+		agg.albums = append(agg.albums, a)
+		ar := artists[a.Artist]
+		if ar == nil {
+			ar = &Artist{Name: a.Artist}
+			artists[a.Artist] = ar
+		}
+		ar.Albums++
+		ar.Tracks += a.Tracks
+	}
+	sort.Slice(agg.albums, func(i, j int) bool {
+		a, b := agg.albums[i], agg.albums[j]
+		if a.Artist != b.Artist {
+			return lessFold(a.Artist, b.Artist)
+		}
+		if a.Year != b.Year {
+			return a.Year < b.Year
+		}
+		return lessFold(a.Name, b.Name)
+	})
+	for _, a := range artists {
+		agg.artists = append(agg.artists, *a)
+	}
+	sort.Slice(agg.artists, func(i, j int) bool {
+		return lessFold(agg.artists[i].Name, agg.artists[j].Name)
+	})
+	for name, n := range genres {
+		agg.genres = append(agg.genres, Genre{Name: name, Tracks: n})
+	}
+	sort.Slice(agg.genres, func(i, j int) bool {
+		return lessFold(agg.genres[i].Name, agg.genres[j].Name)
+	})
+	x.agg = agg
+	return agg
+}
+
+func lessFold(a, b string) bool {
+	x, y := strings.ToLower(a), strings.ToLower(b)
+	if x != y {
+		return x < y
+	}
+	return a < b
+}
+
+// Artists returns all artists sorted by name.
+func (x *Index) Artists() []Artist {
+	return x.aggregate().artists
+}
+
+// Albums returns the albums of artist, or all albums if artist is empty,
+// sorted by artist, year and name. The IDs of the albums are not set.
+func (x *Index) Albums(artist string) []Album {
+	albums := []Album{}
+	for _, a := range x.aggregate().albums {
+		if artist == "" || a.Artist == artist {
+			c := *a
+			c.IDs = nil
+			albums = append(albums, c)
+		}
+	}
+	return albums
+}
+
+// Album returns the album with name by artist, or nil if there is none.
+func (x *Index) Album(artist, name string) *Album {
+	for _, a := range x.aggregate().albums {
+		if a.Artist == artist && a.Name == name {
+			c := *a
+			return &c
+		}
+	}
+	return nil
+}
+
+// Genres returns all genres sorted by name.
+func (x *Index) Genres() []Genre {
+	return x.aggregate().genres
+}
+
+// Folder returns the contents of the directory at path of the instance
+// identified by name and key. The instance's song IDs must start with their
+// file path, as with the file protocol. If path is empty, key is used as the
+// root directory.
+func (x *Index) Folder(name, key, path string) *Folder {
+	if path == "" {
+		path = key
+	}
+	path = filepath.Clean(path)
+	f := &Folder{
+		Path:    path,
+		Folders: []Subfolder{},
+		IDs:     []codec.ID{},
+	}
+	prefix := path + string(filepath.Separator)
+	counts := make(map[string]int)
+	paths := make(map[codec.ID]string)
+	for id := range x.instances[codec.NewID(name, key)] {
+		_, c := id.Pop()
+		_, c = c.Pop()
+		p := c.Top()
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rel := p[len(prefix):]
+		if i := strings.IndexRune(rel, filepath.Separator); i >= 0 {
+			counts[rel[:i]]++
+			continue
+		}
+		f.IDs = append(f.IDs, id)
+		paths[id] = p
+	}
+	for name, n := range counts {
+		f.Folders = append(f.Folders, Subfolder{Name: name, Tracks: n})
+	}
+	sort.Slice(f.Folders, func(i, j int) bool {
+		return lessFold(f.Folders[i].Name, f.Folders[j].Name)
+	})
+	sort.Slice(f.IDs, func(i, j int) bool {
+		a, b := f.IDs[i], f.IDs[j]
+		if paths[a] != paths[b] {
+			return lessFold(paths[a], paths[b])
+		}
+		return a < b
+	})
+	return f
+}
@@ -0,0 +1,135 @@
+package library
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// maxChanges is the number of changed songs an index remembers. Clients
+// further behind must fetch the whole library again.
+const maxChanges = 100000
+
+type changeKind int
+
+const (
+	changeAdd changeKind = iota
+	changeRemove
+	changeUpdate
+)
+
+type change struct {
+	version uint64
+	id      codec.ID
+	kind    changeKind
+}
+
+// A Delta lists the songs that were added, removed or changed between two
+// versions of an index.
+type Delta struct {
+	From, To uint64
+	Added    []codec.ID
+	Changed  []codec.ID
+	Removed  []codec.ID
+}
+
+// Version returns the version of the index. It increases each time the songs
+// of the index change. The first version of an index is derived from the
+// time it was created so versions of earlier indexes are not mistaken for
+// its own.
+func (x *Index) Version() uint64 {
+	return x.version
+}
+
+// Changes returns the changes from version since to the current version. It
+// returns false if since is unknown or too old, in which case the client
+// must fetch all songs again.
+func (x *Index) Changes(since uint64) (*Delta, bool) {
+	if since < x.base || since > x.version {
+		return nil, false
+	}
+	i := sort.Search(len(x.changes), func(i int) bool {
+		return x.changes[i].version > since
+	})
+	// before records whether a song existed at since, now whether it
+	// exists at the current version.
+	type state struct{ before, now bool }
+	states := make(map[codec.ID]*state)
+	for _, c := range x.changes[i:] {
+		s := states[c.id]
+		if s == nil {
+			s = &state{before: c.kind != changeAdd}
+			states[c.id] = s
+		}
+		s.now = c.kind != changeRemove
+	}
+	d := &Delta{
+		From:    since,
+		To:      x.version,
+		Added:   []codec.ID{},
+		Changed: []codec.ID{},
+		Removed: []codec.ID{},
+	}
+	for id, s := range states {
+		switch {
+		case s.before && s.now:
+			d.Changed = append(d.Changed, id)
+		case s.now:
+			d.Added = append(d.Added, id)
+		case s.before:
+			d.Removed = append(d.Removed, id)
+		}
+	}
+	for _, ids := range [][]codec.ID{d.Added, d.Changed, d.Removed} {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return d, true
+}
+
+// IDs returns the IDs of all songs in the index, sorted. The returned slice
+// must not be modified.
+func (x *Index) IDs() []codec.ID {
+	if x.ids == nil {
+		x.ids = make([]codec.ID, 0, len(x.docs))
+		for id := range x.docs {
+			x.ids = append(x.ids, id)
+		}
+		sort.Slice(x.ids, func(i, j int) bool { return x.ids[i] < x.ids[j] })
+	}
+	return x.ids
+}
+
+func initialVersion() uint64 {
+	return uint64(time.Now().UnixNano())
+}
+
+// record notes a change to be included in the next version.
+func (x *Index) record(id codec.ID, kind changeKind) {
+	x.changes = append(x.changes, change{
+		version: x.version + 1,
+		id:      id,
+		kind:    kind,
+	})
+	x.ids = nil
+}
+
+// commit increments the version if any changes were recorded since the last
+// commit and forgets the oldest changes if there are too many.
+func (x *Index) commit() {
+	n := len(x.changes)
+	if n == 0 || x.changes[n-1].version <= x.version {
+		return
+	}
+	x.version++
+	if n <= maxChanges {
+		return
+	}
+	// Only forget whole versions.
+	i := n - maxChanges
+	for i < n && x.changes[i].version == x.changes[i-1].version {
+		i++
+	}
+	x.base = x.changes[i-1].version
+	x.changes = append([]change(nil), x.changes[i:]...)
+}
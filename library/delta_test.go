@@ -0,0 +1,106 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+func TestChanges(t *testing.T) {
+	id := func(s string) codec.ID {
+		return codec.NewID("file", "music").Push(s)
+	}
+	x := New()
+	v0 := x.Version()
+	x.SetInstance("file", "music", protocol.SongList{
+		"a": {Title: "a"},
+		"b": {Title: "b"},
+		"c": {Title: "c"},
+	})
+	v1 := x.Version()
+	if v1 != v0+1 {
+		t.Fatalf("version %d after adding, want %d", v1, v0+1)
+	}
+	// Setting the same songs again is not a change.
+	x.SetInstance("file", "music", protocol.SongList{
+		"a": {Title: "a"},
+		"b": {Title: "b"},
+		"c": {Title: "c"},
+	})
+	if x.Version() != v1 {
+		t.Fatalf("version %d after no change, want %d", x.Version(), v1)
+	}
+	x.SetInstance("file", "music", protocol.SongList{
+		"a": {Title: "a"},
+		"b": {Title: "b2"},
+		"d": {Title: "d"},
+	})
+	v2 := x.Version()
+	// e is added and removed again between v2 and v4, so it is not in the
+	// delta from v2.
+	x.SetInstance("file", "music", protocol.SongList{
+		"a": {Title: "a"},
+		"b": {Title: "b2"},
+		"d": {Title: "d"},
+		"e": {Title: "e"},
+	})
+	x.SetInstance("file", "music", protocol.SongList{
+		"a": {Title: "a"},
+		"b": {Title: "b2"},
+		"d": {Title: "d2"},
+	})
+	v4 := x.Version()
+
+	tests := []struct {
+		since uint64
+		want  Delta
+	}{
+		{v0, Delta{
+			Added:   []codec.ID{id("a"), id("b"), id("d")},
+			Changed: []codec.ID{},
+			Removed: []codec.ID{},
+		}},
+		{v1, Delta{
+			Added:   []codec.ID{id("d")},
+			Changed: []codec.ID{id("b")},
+			Removed: []codec.ID{id("c")},
+		}},
+		{v2, Delta{
+			Added:   []codec.ID{},
+			Changed: []codec.ID{id("d")},
+			Removed: []codec.ID{},
+		}},
+		{v4, Delta{
+			Added:   []codec.ID{},
+			Changed: []codec.ID{},
+			Removed: []codec.ID{},
+		}},
+	}
+	for _, test := range tests {
+		d, ok := x.Changes(test.since)
+		if !ok {
+			t.Errorf("%d: unknown version", test.since-v0)
+			continue
+		}
+		test.want.From, test.want.To = test.since, v4
+		if !reflect.DeepEqual(*d, test.want) {
+			t.Errorf("%d: got %+v, want %+v", test.since-v0, *d, test.want)
+		}
+	}
+	for _, since := range []uint64{v0 - 1, v4 + 1} {
+		if _, ok := x.Changes(since); ok {
+			t.Errorf("Changes(%d) is known, want unknown", since)
+		}
+	}
+
+	x.RemoveInstance("file", "music")
+	d, ok := x.Changes(v4)
+	if !ok {
+		t.Fatal("unknown version after removing")
+	}
+	if want := []codec.ID{id("a"), id("b"), id("d")}; !reflect.DeepEqual(d.Removed, want) {
+		t.Errorf("removed %v, want %v", d.Removed, want)
+	}
+}
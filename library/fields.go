@@ -0,0 +1,289 @@
+package library
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// A Doc is a song in the library.
+type Doc struct {
+	// ID is the full song ID: protocol, key and protocol-specific ID.
+	ID    codec.ID
+	Info  *codec.SongInfo
+	Stats Stats
+}
+
+// Stats are the listening statistics of a song.
+type Stats struct {
+	Plays int `json:",omitempty"`
+	Skips int `json:",omitempty"`
+	// LastPlayed is the start of the last play that was listened to,
+	// completed or not.
+	LastPlayed time.Time `json:",omitempty"`
+	// Rating is from 0 to 5 in steps of 0.5, where 0 is unrated.
+	Rating   float64 `json:",omitempty"`
+	Favorite bool    `json:",omitempty"`
+}
+
+type field struct {
+	// Exactly one of text and number is set.
+	text   func(*Doc) string
+	number func(*Doc) float64
+	// parse parses a query value of a number field.
+	parse func(string) (float64, error)
+	// stats is set for fields of Doc.Stats.
+	stats bool
+}
+
+func textField(f func(*codec.SongInfo) string) field {
+	return field{text: func(d *Doc) string { return f(d.Info) }}
+}
+
+func numberField(f func(*codec.SongInfo) float64) field {
+	return field{
+		number: func(d *Doc) float64 { return f(d.Info) },
+		parse:  parseNumber,
+	}
+}
+
+func statsField(f func(Stats) float64) field {
+	return field{
+		number: func(d *Doc) float64 { return f(d.Stats) },
+		parse:  parseNumber,
+		stats:  true,
+	}
+}
+
+// fields are the fields that can be used in queries.
+var fields = map[string]field{
+	"artist":      textField(func(si *codec.SongInfo) string { return si.Artist }),
+	"albumartist": textField(func(si *codec.SongInfo) string { return si.AlbumArtist }),
+	"title":       textField(func(si *codec.SongInfo) string { return si.Title }),
+	"album":       textField(func(si *codec.SongInfo) string { return si.Album }),
+	"genre":       textField(func(si *codec.SongInfo) string { return si.Genre }),
+	"composer":    textField(func(si *codec.SongInfo) string { return si.Composer }),
+	"comment":     textField(func(si *codec.SongInfo) string { return si.Comment }),
+	"codec":       textField(func(si *codec.SongInfo) string { return si.Codec }),
+	"protocol": {text: func(d *Doc) string {
+		return d.ID.Top()
+	}},
+	"path": {text: func(d *Doc) string {
+		_, id := d.ID.Pop()
+		_, id = id.Pop()
+		return id.Top()
+	}},
+	"year":     numberField(func(si *codec.SongInfo) float64 { return float64(si.Year) }),
+	"track":    numberField(func(si *codec.SongInfo) float64 { return si.Track }),
+	"disc":     numberField(func(si *codec.SongInfo) float64 { return float64(si.Disc) }),
+	"channels": numberField(func(si *codec.SongInfo) float64 { return float64(si.Channels) }),
+	"bitdepth": numberField(func(si *codec.SongInfo) float64 { return float64(si.BitDepth) }),
+	// Bitrates are queried in kbps, like "bitrate:>=320".
+	"bitrate": numberField(func(si *codec.SongInfo) float64 { return float64(si.Bitrate) / 1000 }),
+	// Sample rates are queried in Hz, like "samplerate:96k".
+	"samplerate": numberField(func(si *codec.SongInfo) float64 { return float64(si.SampleRate) }),
+	"size": {
+		number: func(d *Doc) float64 { return float64(d.Info.FileSize) },
+		parse:  parseSize,
+	},
+	"time": {
+		number: func(d *Doc) float64 { return d.Info.Time.Seconds() },
+		parse:  parseSeconds,
+	},
+	"plays":  statsField(func(s Stats) float64 { return float64(s.Plays) }),
+	"skips":  statsField(func(s Stats) float64 { return float64(s.Skips) }),
+	"rating": statsField(func(s Stats) float64 { return s.Rating }),
+	// Favorites are queried like "favorite:yes".
+	"favorite": {
+		number: func(d *Doc) float64 {
+			if d.Stats.Favorite {
+				return 1
+			}
+			return 0
+		},
+		parse: parseBool,
+		stats: true,
+	},
+	// Last played is queried as an age, so "lastplayed:>30d" matches songs
+	// last played more than 30 days ago or never played.
+	"lastplayed": {
+		number: func(d *Doc) float64 {
+			if d.Stats.LastPlayed.IsZero() {
+				return math.Inf(1)
+			}
+			return time.Since(d.Stats.LastPlayed).Seconds()
+		},
+		parse: parseAge,
+		stats: true,
+	},
+}
+
+func init() {
+	fields["length"] = fields["time"]
+	fields["duration"] = fields["time"]
+}
+
+// textFields are the fields searched by rules without a field.
+var textFields = []string{"artist", "albumartist", "title", "album", "genre", "composer"}
+
+// parseNumber parses a number with an optional k or m suffix, like "96k".
+func parseNumber(s string) (float64, error) {
+	s = strings.ToLower(s)
+	mult := 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult = 1e3
+	case strings.HasSuffix(s, "m"):
+		mult = 1e6
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f * mult, err
+}
+
+// parseSize parses a size in bytes with an optional kb, mb or gb suffix.
+func parseSize(s string) (float64, error) {
+	s = strings.TrimSuffix(strings.ToLower(s), "b")
+	mult := 1.0
+	for i, suffix := range []string{"k", "m", "g"} {
+		if strings.HasSuffix(s, suffix) {
+			mult = float64(int64(1) << (10 * (i + 1)))
+			s = s[:len(s)-1]
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return f * mult, err
+}
+
+// parseSeconds parses a duration like "3m30s" or a number of seconds.
+func parseSeconds(s string) (float64, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad duration: %s", s)
+	}
+	return d.Seconds(), nil
+}
+
+// parseBool parses a boolean as 1 or 0.
+func parseBool(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "1", "true", "yes", "y":
+		return 1, nil
+	case "0", "false", "no", "n":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("bad boolean: %s", s)
+}
+
+// parseAge parses an age in seconds like "30d", "2w", "1y" or "12h". A
+// number without a unit is a number of days.
+func parseAge(s string) (float64, error) {
+	s = strings.ToLower(s)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f * 86400, nil
+	}
+	for suffix, mult := range map[string]float64{
+		"d": 86400,
+		"w": 7 * 86400,
+		"y": 365 * 86400,
+	} {
+		if strings.HasSuffix(s, suffix) {
+			f, err := strconv.ParseFloat(s[:len(s)-1], 64)
+			if err != nil {
+				break
+			}
+			return f * mult, nil
+		}
+	}
+	return parseSeconds(s)
+}
+
+// Match reports whether d matches all rules of q.
+func (q Query) Match(d *Doc) bool {
+	for _, r := range q {
+		if r.Match(d) == r.Not {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether d matches r, ignoring r.Not.
+func (r Rule) Match(d *Doc) bool {
+	if r.Field == "" {
+		for _, name := range textFields {
+			if matchText(r.Op, fields[name].text(d), r.Value) {
+				return true
+			}
+		}
+		return false
+	}
+	f := fields[r.Field]
+	if f.text != nil {
+		return matchText(r.Op, f.text(d), r.Value)
+	}
+	want, err := f.parse(r.Value)
+	if err != nil {
+		return false
+	}
+	return compare(r.Op, f.number(d), want)
+}
+
+func matchText(op Op, have, want string) bool {
+	switch op {
+	case OpMatch:
+		return hasWords(have, want)
+	case OpEq:
+		return strings.EqualFold(have, want)
+	case OpNe:
+		return !strings.EqualFold(have, want)
+	}
+	c := strings.Compare(strings.ToLower(have), strings.ToLower(want))
+	return compare(op, float64(c), 0)
+}
+
+// hasWords reports whether each word of want is a prefix of a word of have.
+func hasWords(have, want string) bool {
+	hw := words(have)
+	for _, w := range words(want) {
+		found := false
+		for _, h := range hw {
+			if strings.HasPrefix(h, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func compare(op Op, have, want float64) bool {
+	switch op {
+	case OpMatch, OpEq:
+		return have == want
+	case OpNe:
+		return have != want
+	case OpLt:
+		return have < want
+	case OpLe:
+		return have <= want
+	case OpGt:
+		return have > want
+	case OpGe:
+		return have >= want
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+// Package library indexes the songs of all protocol instances for searching
+// and browsing.
+package library
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+// Index is an in-memory inverted index of songs. It is not safe for
+// concurrent use.
+type Index struct {
+	docs map[codec.ID]*Doc
+	// postings maps lower case words to the songs with that word in a text
+	// field.
+	postings map[string]map[codec.ID]bool
+	// terms are the sorted keys of postings, or nil if they need to be
+	// rebuilt.
+	terms []string
+	// instances maps a protocol and key to the IDs of its songs.
+	instances map[codec.ID]map[codec.ID]bool
+	// stats are the statistics of songs, including songs not in the index.
+	stats map[codec.ID]Stats
+	// agg caches the browse views, or is nil if they need to be rebuilt.
+	agg *aggregate
+	// ids are the sorted keys of docs, or nil if they need to be rebuilt.
+	ids []codec.ID
+	// paths maps the paths of songs to their IDs, or is nil if it needs to
+	// be rebuilt.
+	paths map[string]codec.ID
+
+	// version is the current version. changes are the changes after base,
+	// ordered by version.
+	version, base uint64
+	changes       []change
+}
+
+// New returns an empty index.
+func New() *Index {
+	v := initialVersion()
+	return &Index{
+		docs:      make(map[codec.ID]*Doc),
+		postings:  make(map[string]map[codec.ID]bool),
+		instances: make(map[codec.ID]map[codec.ID]bool),
+		stats:     make(map[codec.ID]Stats),
+		version:   v,
+		base:      v,
+	}
+}
+
+// Len returns the number of songs in the index.
+func (x *Index) Len() int {
+	return len(x.docs)
+}
+
+// Get returns the song with id, or nil.
+func (x *Index) Get(id codec.ID) *Doc {
+	return x.docs[id]
+}
+
+// SetInstance replaces the songs of the instance identified by name and key.
+func (x *Index) SetInstance(name, key string, songs protocol.SongList) {
+	prefix := codec.NewID(name, key)
+	old := x.instances[prefix]
+	ids := make(map[codec.ID]bool, len(songs))
+	for id, info := range songs {
+		if info == nil {
+			continue
+		}
+		sid := prefix.Push(string(id))
+		ids[sid] = true
+		kind := changeAdd
+		if d := x.docs[sid]; d != nil {
+			if d.Info.Equal(info) {
+				// The aggregates hold no pointers to Info, so they stay
+				// valid.
+				d.Info = info
+				continue
+			}
+			kind = changeUpdate
+		}
+		x.remove(sid)
+		x.add(&Doc{ID: sid, Info: info})
+		x.record(sid, kind)
+	}
+	for id := range old {
+		if !ids[id] {
+			x.remove(id)
+			x.record(id, changeRemove)
+		}
+	}
+	x.instances[prefix] = ids
+	x.commit()
+}
+
+// RemoveInstance removes the songs of the instance identified by name and
+// key.
+func (x *Index) RemoveInstance(name, key string) {
+	prefix := codec.NewID(name, key)
+	for id := range x.instances[prefix] {
+		x.remove(id)
+		x.record(id, changeRemove)
+	}
+	delete(x.instances, prefix)
+	x.commit()
+}
+
+// SetStats sets the statistics of the song with id. They are kept if the
+// song is removed and added again. Setting statistics does not change the
+// version of the index.
+func (x *Index) SetStats(id codec.ID, s Stats) {
+	if s == (Stats{}) {
+		delete(x.stats, id)
+	} else {
+		x.stats[id] = s
+	}
+	if d := x.docs[id]; d != nil {
+		d.Stats = s
+	}
+}
+
+func (x *Index) add(d *Doc) {
+	d.Stats = x.stats[d.ID]
+	x.docs[d.ID] = d
+	x.agg = nil
+	x.paths = nil
+	for _, w := range docWords(d) {
+		p := x.postings[w]
+		if p == nil {
+			p = make(map[codec.ID]bool)
+			x.postings[w] = p
+			x.terms = nil
+		}
+		p[d.ID] = true
+	}
+}
+
+func (x *Index) remove(id codec.ID) {
+	d := x.docs[id]
+	if d == nil {
+		return
+	}
+	x.agg = nil
+	x.paths = nil
+	for _, w := range docWords(d) {
+		p := x.postings[w]
+		delete(p, id)
+		if len(p) == 0 {
+			delete(x.postings, w)
+			x.terms = nil
+		}
+	}
+	delete(x.docs, id)
+}
+
+// docWords returns the unique words of the text fields of d.
+func docWords(d *Doc) []string {
+	seen := make(map[string]bool)
+	var ws []string
+	for _, name := range textFields {
+		for _, w := range words(fields[name].text(d)) {
+			if !seen[w] {
+				seen[w] = true
+				ws = append(ws, w)
+			}
+		}
+	}
+	return ws
+}
+
+// prefixed returns the songs with a word starting with prefix.
+func (x *Index) prefixed(prefix string) map[codec.ID]bool {
+	if x.terms == nil {
+		x.terms = make([]string, 0, len(x.postings))
+		for t := range x.postings {
+			x.terms = append(x.terms, t)
+		}
+		sort.Strings(x.terms)
+	}
+	ids := make(map[codec.ID]bool)
+	for i := sort.SearchStrings(x.terms, prefix); i < len(x.terms) && strings.HasPrefix(x.terms[i], prefix); i++ {
+		for id := range x.postings[x.terms[i]] {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// candidates returns the songs that may match q, or nil if q can't use the
+// index and all songs must be checked.
+func (x *Index) candidates(q Query) map[codec.ID]bool {
+	var c map[codec.ID]bool
+	for _, r := range q {
+		if r.Not || r.Op != OpMatch {
+			continue
+		}
+		// Only fields that are indexed can narrow the search.
+		indexed := r.Field == ""
+		for _, name := range textFields {
+			indexed = indexed || r.Field == name
+		}
+		if !indexed {
+			continue
+		}
+		for _, w := range words(r.Value) {
+			ids := x.prefixed(w)
+			if c == nil {
+				c = ids
+				continue
+			}
+			for id := range c {
+				if !ids[id] {
+					delete(c, id)
+				}
+			}
+		}
+	}
+	return c
+}
+
+// Search returns the IDs of the songs matching q, sorted by Less.
+func (x *Index) Search(q Query) []codec.ID {
+	var docs []*Doc
+	if c := x.candidates(q); c != nil {
+		for id := range c {
+			if d := x.docs[id]; q.Match(d) {
+				docs = append(docs, d)
+			}
+		}
+	} else {
+		for _, d := range x.docs {
+			if q.Match(d) {
+				docs = append(docs, d)
+			}
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Less(docs[j])
+	})
+	ids := make([]codec.ID, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	return ids
+}
+
+// Less orders songs by artist, album, disc, track and title.
+func (d *Doc) Less(o *Doc) bool {
+	a, b := d.Info, o.Info
+	if x, y := albumArtist(a), albumArtist(b); x != y {
+		return x < y
+	}
+	if a.Album != b.Album {
+		return a.Album < b.Album
+	}
+	if a.Disc != b.Disc || a.Track != b.Track {
+		return a.Less(b)
+	}
+	if a.Title != b.Title {
+		return a.Title < b.Title
+	}
+	return d.ID < o.ID
+}
+
+// albumArtist returns the artist an album is listed under.
+func albumArtist(si *codec.SongInfo) string {
+	if si.AlbumArtist != "" {
+		return si.AlbumArtist
+	}
+	return si.Artist
+}
@@ -0,0 +1,79 @@
+package library
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// Path returns the song whose ID has path p, as with the file protocol. If p
+// is relative, it matches the song whose path ends with p, if there is only
+// one. Of files with several songs, the first song is returned.
+func (x *Index) Path(p string) (codec.ID, bool) {
+	if x.paths == nil {
+		x.paths = make(map[string]codec.ID)
+		for id, d := range x.docs {
+			p := fields["path"].text(d)
+			if cur, ok := x.paths[p]; !ok || id < cur {
+				x.paths[p] = id
+			}
+		}
+	}
+	if filepath.IsAbs(p) {
+		id, ok := x.paths[filepath.Clean(p)]
+		return id, ok
+	}
+	suffix := string(filepath.Separator) + filepath.Clean(p)
+	var found codec.ID
+	for path, id := range x.paths {
+		if !strings.HasSuffix(path, suffix) {
+			continue
+		}
+		if found != "" {
+			return "", false
+		}
+		found = id
+	}
+	return found, found != ""
+}
+
+// durationSlack is how far the duration of a song may be from the one given
+// to Find.
+const durationSlack = 3 * time.Second
+
+// Find returns the song with artist and title, compared without case. If d
+// is not zero, the song's duration must be close to d if it is known. If
+// several songs match, the one with the closest duration is returned.
+func (x *Index) Find(artist, title string, d time.Duration) (codec.ID, bool) {
+	if title == "" {
+		return "", false
+	}
+	q := Query{{Field: "title", Op: OpMatch, Value: title}}
+	if artist != "" {
+		q = append(q, Rule{Field: "artist", Op: OpMatch, Value: artist})
+	}
+	var found codec.ID
+	var best time.Duration = -1
+	for _, id := range x.Search(q) {
+		si := x.docs[id].Info
+		if !strings.EqualFold(si.Title, title) || (artist != "" && !strings.EqualFold(si.Artist, artist)) {
+			continue
+		}
+		diff := time.Duration(0)
+		if d != 0 && si.Time != 0 {
+			diff = si.Time - d
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > durationSlack {
+				continue
+			}
+		}
+		if best < 0 || diff < best {
+			found, best = id, diff
+		}
+	}
+	return found, best >= 0
+}
@@ -0,0 +1,158 @@
+package library
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Op is a comparison operator of a Rule.
+type Op string
+
+const (
+	// OpMatch matches text fields whose words start with the words of the
+	// value, and number fields equal to the value.
+	OpMatch Op = ":"
+	OpEq    Op = "="
+	OpNe    Op = "!="
+	OpLt    Op = "<"
+	OpLe    Op = "<="
+	OpGt    Op = ">"
+	OpGe    Op = ">="
+)
+
+// ops is ordered so that longer operators are tried first.
+var ops = []Op{OpLe, OpGe, OpNe, OpLt, OpGt, OpEq}
+
+// A Rule is a single condition on a song.
+type Rule struct {
+	// Field is the field name. If empty, the rule matches any text field.
+	Field string `json:",omitempty"`
+	Op    Op
+	Value string
+	// Not negates the rule.
+	Not bool `json:",omitempty"`
+}
+
+// A Query matches songs that match all of its rules.
+type Query []Rule
+
+// Parse parses a query like:
+//
+//	artist:"daft punk" year:>2000 codec:flac -genre:house around
+//
+// Terms are separated by spaces. A term is an optional "-" to negate it, an
+// optional field name followed by ":", an optional comparison operator (=,
+// !=, <, <=, >, >=), and a value that may be double quoted.
+func Parse(s string) (Query, error) {
+	var q Query
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			break
+		}
+		var r Rule
+		if s[0] == '-' {
+			r.Not = true
+			s = s[1:]
+		}
+		// A field name is a run of letters followed by ":".
+		i := strings.IndexFunc(s, func(c rune) bool {
+			return !unicode.IsLetter(c)
+		})
+		// Unknown field names are treated as part of the value so that
+		// titles like "Re:Stacks" can be searched.
+		if i > 0 && s[i] == ':' {
+			if _, ok := fields[strings.ToLower(s[:i])]; ok {
+				r.Field = strings.ToLower(s[:i])
+				s = s[i+1:]
+			}
+		}
+		r.Op = OpMatch
+		for _, op := range ops {
+			if strings.HasPrefix(s, string(op)) {
+				r.Op = op
+				s = s[len(op):]
+				break
+			}
+		}
+		var err error
+		r.Value, s, err = value(s)
+		if err != nil {
+			return nil, err
+		}
+		if r.Field == "" && r.Op != OpMatch {
+			return nil, fmt.Errorf("operator %s needs a field", r.Op)
+		}
+		if r.Value == "" && r.Op != OpEq && r.Op != OpNe {
+			continue
+		}
+		if err := r.check(); err != nil {
+			return nil, err
+		}
+		q = append(q, r)
+	}
+	return q, nil
+}
+
+// value reads a possibly quoted value from the start of s and returns it and
+// the rest of s.
+func value(s string) (v, rest string, err error) {
+	if strings.HasPrefix(s, `"`) {
+		i := strings.IndexByte(s[1:], '"')
+		if i < 0 {
+			return "", "", fmt.Errorf("unterminated quote")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	}
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, "", nil
+	}
+	return s[:i], s[i:], nil
+}
+
+// check verifies the value of r can be parsed for its field.
+func (r Rule) check() error {
+	f := fields[r.Field]
+	if f.number == nil {
+		return nil
+	}
+	if _, err := f.parse(r.Value); err != nil {
+		return fmt.Errorf("%s: bad value %q", r.Field, r.Value)
+	}
+	return nil
+}
+
+// String formats q so that Parse(q.String()) is equivalent to q.
+func (q Query) String() string {
+	var b strings.Builder
+	for i, r := range q {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if r.Not {
+			b.WriteByte('-')
+		}
+		if r.Field != "" {
+			b.WriteString(r.Field)
+			b.WriteByte(':')
+		}
+		if r.Op != OpMatch {
+			b.WriteString(string(r.Op))
+		}
+		if r.Value == "" || strings.IndexFunc(r.Value, unicode.IsSpace) >= 0 {
+			fmt.Fprintf(&b, `"%s"`, r.Value)
+		} else {
+			b.WriteString(r.Value)
+		}
+	}
+	return b.String()
+}
+
+// words splits s into lower case words.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+}
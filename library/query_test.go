@@ -0,0 +1,76 @@
+package library
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Query
+	}{
+		{"", nil},
+		{"around", Query{{Op: OpMatch, Value: "around"}}},
+		{"  daft   punk ", Query{{Op: OpMatch, Value: "daft"}, {Op: OpMatch, Value: "punk"}}},
+		{`"daft punk"`, Query{{Op: OpMatch, Value: "daft punk"}}},
+		{`artist:"daft punk"`, Query{{Field: "artist", Op: OpMatch, Value: "daft punk"}}},
+		{"ARTIST:abba", Query{{Field: "artist", Op: OpMatch, Value: "abba"}}},
+		{"-genre:house", Query{{Field: "genre", Op: OpMatch, Value: "house", Not: true}}},
+		{`-"live at"`, Query{{Op: OpMatch, Value: "live at", Not: true}}},
+		// Unknown fields are part of the value.
+		{"Re:Stacks", Query{{Op: OpMatch, Value: "Re:Stacks"}}},
+		{"year:>2000", Query{{Field: "year", Op: OpGt, Value: "2000"}}},
+		{"year:>=1990 year:<2000", Query{
+			{Field: "year", Op: OpGe, Value: "1990"},
+			{Field: "year", Op: OpLt, Value: "2000"},
+		}},
+		{"year:<=1999 track:!=3", Query{
+			{Field: "year", Op: OpLe, Value: "1999"},
+			{Field: "track", Op: OpNe, Value: "3"},
+		}},
+		{"samplerate:96k size:>10mb time:<3m30s", Query{
+			{Field: "samplerate", Op: OpMatch, Value: "96k"},
+			{Field: "size", Op: OpGt, Value: "10mb"},
+			{Field: "time", Op: OpLt, Value: "3m30s"},
+		}},
+		// Empty values are dropped unless they test for equality.
+		{"title: abba", Query{{Op: OpMatch, Value: "abba"}}},
+		{`genre:= comment:!=""`, Query{
+			{Field: "genre", Op: OpEq},
+			{Field: "comment", Op: OpNe},
+		}},
+	}
+	for _, test := range tests {
+		q, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%q: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(q, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.in, q, test.want)
+			continue
+		}
+		// String must round trip.
+		q2, err := Parse(q.String())
+		if err != nil {
+			t.Errorf("%q: reparse %q: %v", test.in, q.String(), err)
+		} else if !reflect.DeepEqual(q2, q) {
+			t.Errorf("%q: reparse %q: got %+v, want %+v", test.in, q.String(), q2, q)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, in := range []string{
+		">3",
+		`artist:"daft punk`,
+		"year:abc",
+		"year:>soon",
+		"size:<lots",
+	} {
+		if q, err := Parse(in); err == nil {
+			t.Errorf("%q: got %+v, want error", in, q)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package library
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// Smart describes a playlist of the songs matching a set of rules.
+type Smart struct {
+	Rules []Rule
+	// Any makes songs match if they match any rule instead of all rules.
+	Any bool `json:",omitempty"`
+	// Limit is the maximum number of songs, or 0 for no limit.
+	Limit int `json:",omitempty"`
+	// Order is the name of a field to sort by, prefixed with "-" to sort in
+	// descending order, or "random". If empty, songs are sorted by artist,
+	// album and track.
+	Order string `json:",omitempty"`
+}
+
+const orderRandom = "random"
+
+// Check verifies the fields and values of s.
+func (s Smart) Check() error {
+	for _, r := range s.Rules {
+		if _, ok := fields[r.Field]; !ok && r.Field != "" {
+			return fmt.Errorf("unknown field: %s", r.Field)
+		}
+		switch r.Op {
+		case OpMatch, OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		default:
+			return fmt.Errorf("unknown operator: %s", r.Op)
+		}
+		if r.Field == "" && r.Op != OpMatch {
+			return fmt.Errorf("operator %s needs a field", r.Op)
+		}
+		if err := r.check(); err != nil {
+			return err
+		}
+	}
+	if s.Limit < 0 {
+		return fmt.Errorf("bad limit: %d", s.Limit)
+	}
+	if o := strings.TrimPrefix(s.Order, "-"); o != "" && o != orderRandom {
+		if _, ok := fields[o]; !ok {
+			return fmt.Errorf("unknown order: %s", s.Order)
+		}
+	}
+	return nil
+}
+
+// UsesStats reports whether the songs of s depend on song statistics, so
+// it must be evaluated again when they change.
+func (s Smart) UsesStats() bool {
+	for _, r := range s.Rules {
+		if fields[r.Field].stats {
+			return true
+		}
+	}
+	return fields[strings.TrimPrefix(s.Order, "-")].stats
+}
+
+func (s Smart) match(d *Doc) bool {
+	if !s.Any {
+		return Query(s.Rules).Match(d)
+	}
+	for _, r := range s.Rules {
+		if r.Match(d) != r.Not {
+			return true
+		}
+	}
+	return false
+}
+
+// Smart returns the IDs of the songs of s, which must have been checked.
+func (x *Index) Smart(s Smart) []codec.ID {
+	var docs []*Doc
+	for _, d := range x.docs {
+		if s.match(d) {
+			docs = append(docs, d)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Less(docs[j])
+	})
+	switch o := s.Order; o {
+	case "":
+	case orderRandom:
+		rand.Shuffle(len(docs), func(i, j int) {
+			docs[i], docs[j] = docs[j], docs[i]
+		})
+	default:
+		desc := strings.HasPrefix(o, "-")
+		f := fields[strings.TrimPrefix(o, "-")]
+		sort.SliceStable(docs, func(i, j int) bool {
+			a, b := docs[i], docs[j]
+			if desc {
+				a, b = b, a
+			}
+			if f.text != nil {
+				return strings.ToLower(f.text(a)) < strings.ToLower(f.text(b))
+			}
+			return f.number(a) < f.number(b)
+		})
+	}
+	if s.Limit > 0 && len(docs) > s.Limit {
+		docs = docs[:s.Limit]
+	}
+	ids := make([]codec.ID, len(docs))
+	for i, d := range docs {
+		ids[i] = d.ID
+	}
+	return ids
+}
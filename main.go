@@ -2,14 +2,17 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/facebookgo/httpcontrol"
+	"github.com/mjibson/moggio/ctl"
 	"github.com/mjibson/moggio/server"
 
 	// codecs
@@ -29,11 +32,27 @@ import (
 var (
 	flagAddr  = flag.String("addr", ":6601", "listen address")
 	flagDev   = flag.Bool("dev", false, "enable dev mode")
+	flagMPD   = flag.String("mpd", "", "MPD protocol listen address, like :6600; disabled if empty")
 	stateFile = flag.String("state", "", "specify non-default statefile location")
 )
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: moggio [flags]\n       moggio [flags] ctl [-json] command [args]\n\nflags:\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if flag.Arg(0) == "ctl" {
+		host := *flagAddr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		if err := ctl.Main("http://"+host, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "moggio ctl:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	http.DefaultClient = &http.Client{
 		Transport: &httpcontrol.Transport{
 			ResponseHeaderTimeout: time.Second * 3,
@@ -55,7 +74,7 @@ func main() {
 			*stateFile = filepath.Join(os.Getenv("HOME"), ".moggio.state")
 		}
 	}
-	log.Fatal(server.ListenAndServe(*stateFile, *flagAddr, *flagDev))
+	log.Fatal(server.ListenAndServe(*stateFile, *flagAddr, *flagMPD, *flagDev))
 }
 
 //go:generate browserify -t [ reactify --es6 ] server/static/src/nav.js -o server/static/js/moggio.js
@@ -0,0 +1,70 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func decodeM3U(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	var e Entry
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			i := strings.IndexByte(info, ',')
+			if i < 0 {
+				continue
+			}
+			// The duration may be followed by attributes.
+			secs := strings.Fields(info[:i])
+			if len(secs) > 0 {
+				if f, err := strconv.ParseFloat(secs[0], 64); err == nil && f > 0 {
+					e.Duration = time.Duration(f * float64(time.Second))
+				}
+			}
+			e.setDisplay(info[i+1:])
+		case strings.HasPrefix(line, "#EXTALB:"):
+			e.Album = strings.TrimSpace(strings.TrimPrefix(line, "#EXTALB:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			e.Location = line
+			entries = append(entries, e)
+			e = Entry{}
+		}
+	}
+	return entries, s.Err()
+}
+
+func encodeM3U(w io.Writer, title string, entries []Entry) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	if title != "" {
+		fmt.Fprintf(b, "#PLAYLIST:%s\n", oneLine(title))
+	}
+	for _, e := range entries {
+		secs := -1
+		if e.Duration > 0 {
+			secs = int(e.Duration.Round(time.Second) / time.Second)
+		}
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", secs, oneLine(e.display()))
+		if e.Album != "" {
+			fmt.Fprintf(b, "#EXTALB:%s\n", oneLine(e.Album))
+		}
+		fmt.Fprintln(b, oneLine(e.Location))
+	}
+	return b.Flush()
+}
+
+// oneLine replaces line breaks in s with spaces.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,130 @@
+// Package playlist reads and writes playlist files in the M3U8, PLS and
+// XSPF formats.
+package playlist
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats supported by Decode and Encode.
+const (
+	M3U8 = "m3u8"
+	PLS  = "pls"
+	XSPF = "xspf"
+)
+
+// An Entry is a song of a playlist. Location is a file path or a URL.
+// Decode converts file URLs to paths.
+type Entry struct {
+	Location string
+	Artist   string
+	Title    string
+	Album    string
+	Duration time.Duration
+}
+
+// Format returns the format of the playlist file name, or name itself if it
+// is the name of a format.
+func Format(name string) (string, error) {
+	f := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	if f == "" {
+		f = strings.ToLower(name)
+	}
+	switch f {
+	case "m3u", M3U8:
+		return M3U8, nil
+	case PLS, XSPF:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown playlist format: %s", name)
+}
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case M3U8:
+		return "audio/x-mpegurl"
+	case PLS:
+		return "audio/x-scpls"
+	case XSPF:
+		return "application/xspf+xml"
+	}
+	return "application/octet-stream"
+}
+
+// Decode reads a playlist in format from r.
+func Decode(r io.Reader, format string) ([]Entry, error) {
+	var entries []Entry
+	var err error
+	switch format {
+	case M3U8:
+		entries, err = decodeM3U(r)
+	case PLS:
+		entries, err = decodePLS(r)
+	case XSPF:
+		entries, err = decodeXSPF(r)
+	default:
+		return nil, fmt.Errorf("unknown playlist format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if u, err := url.Parse(e.Location); err == nil && u.Scheme == "file" {
+			entries[i].Location = filepath.FromSlash(u.Path)
+		}
+	}
+	return entries, nil
+}
+
+// Encode writes entries as a playlist in format to w. title is the name of
+// the playlist.
+func Encode(w io.Writer, format, title string, entries []Entry) error {
+	switch format {
+	case M3U8:
+		return encodeM3U(w, title, entries)
+	case PLS:
+		return encodePLS(w, entries)
+	case XSPF:
+		return encodeXSPF(w, title, entries)
+	}
+	return fmt.Errorf("unknown playlist format: %s", format)
+}
+
+// display formats the artist and title of e like "Artist - Title".
+func (e Entry) display() string {
+	switch {
+	case e.Artist == "":
+		return e.Title
+	case e.Title == "":
+		return e.Artist
+	}
+	return e.Artist + " - " + e.Title
+}
+
+// setDisplay sets the artist and title of e from a string like "Artist -
+// Title".
+func (e *Entry) setDisplay(s string) {
+	if i := strings.Index(s, " - "); i >= 0 {
+		e.Artist, e.Title = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+3:])
+	} else {
+		e.Title = strings.TrimSpace(s)
+	}
+}
+
+// fileURL returns loc as a file URL if it is an absolute path.
+func fileURL(loc string) string {
+	if !filepath.IsAbs(loc) {
+		return loc
+	}
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(loc),
+	}
+	return u.String()
+}
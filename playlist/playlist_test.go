@@ -0,0 +1,115 @@
+package playlist
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{
+			Location: filepath.FromSlash("/music/Daft Punk/Discovery/01 One More Time.flac"),
+			Artist:   "Daft Punk",
+			Title:    "One More Time",
+			Album:    "Discovery",
+			Duration: 5*time.Minute + 20*time.Second,
+		},
+		{
+			Location: "http://example.com/stream.mp3",
+			Title:    "Radio",
+		},
+		{
+			Location: filepath.FromSlash("relative/path.mp3"),
+			Artist:   "A - B",
+			Title:    "Line\nbreak",
+			Duration: time.Second,
+		},
+	}
+	for _, format := range []string{M3U8, PLS, XSPF} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, format, "Mix", entries); err != nil {
+			t.Errorf("%s: encode: %v", format, err)
+			continue
+		}
+		got, err := Decode(&buf, format)
+		if err != nil {
+			t.Errorf("%s: decode: %v", format, err)
+			continue
+		}
+		want := make([]Entry, len(entries))
+		copy(want, entries)
+		// Text formats join the artist and title and split them at the
+		// first " - ", and have no line breaks.
+		if format != XSPF {
+			want[2].Artist, want[2].Title = "A", "B - Line break"
+		}
+		// PLS has no albums.
+		if format == PLS {
+			want[0].Album = ""
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", format, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		format string
+		in     string
+		want   []Entry
+	}{
+		{M3U8, "\ufeff#EXTM3U\n" +
+			"#EXTINF:123 tvg-id=\"x\",Artist - Title\n" +
+			"a.mp3\n" +
+			"\n" +
+			"# comment\n" +
+			"#EXTINF:-1,Just a title\r\n" +
+			"file:///music/b.mp3\r\n" +
+			"c.mp3\n",
+			[]Entry{
+				{Location: "a.mp3", Artist: "Artist", Title: "Title", Duration: 123 * time.Second},
+				{Location: filepath.FromSlash("/music/b.mp3"), Title: "Just a title"},
+				{Location: "c.mp3"},
+			},
+		},
+		{PLS, "[playlist]\n" +
+			"File2=b.mp3\n" +
+			"Title1=Artist - Title\n" +
+			"file1=a.mp3\n" +
+			"Length1=60\n" +
+			"Title3=No file\n" +
+			"NumberOfEntries=3\n",
+			[]Entry{
+				{Location: "a.mp3", Artist: "Artist", Title: "Title", Duration: time.Minute},
+				{Location: "b.mp3"},
+			},
+		},
+		{XSPF, `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track><location>file:///music/a%20b.ogg</location><creator>Artist</creator><duration>1500</duration></track>
+    <track><title>Only title</title></track>
+  </trackList>
+</playlist>`,
+			[]Entry{
+				{Location: filepath.FromSlash("/music/a b.ogg"), Artist: "Artist", Duration: 1500 * time.Millisecond},
+				{Title: "Only title"},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := Decode(strings.NewReader(test.in), test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.format, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func decodePLS(r io.Reader) ([]Entry, error) {
+	m := make(map[int]*Entry)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			continue
+		}
+		key, value := strings.ToLower(line[:i]), line[i+1:]
+		var name string
+		for _, k := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, k) {
+				name = k
+				break
+			}
+		}
+		if name == "" {
+			continue
+		}
+		n, err := strconv.Atoi(key[len(name):])
+		if err != nil {
+			continue
+		}
+		e := m[n]
+		if e == nil {
+			e = new(Entry)
+			m[n] = e
+		}
+		switch name {
+		case "file":
+			e.Location = value
+		case "title":
+			e.setDisplay(value)
+		case "length":
+			if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+				e.Duration = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	var ns []int
+	for n, e := range m {
+		if e.Location != "" {
+			ns = append(ns, n)
+		}
+	}
+	sort.Ints(ns)
+	entries := make([]Entry, len(ns))
+	for i, n := range ns {
+		entries[i] = *m[n]
+	}
+	return entries, nil
+}
+
+func encodePLS(w io.Writer, entries []Entry) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "[playlist]")
+	for i, e := range entries {
+		n := i + 1
+		fmt.Fprintf(b, "File%d=%s\n", n, oneLine(e.Location))
+		if d := e.display(); d != "" {
+			fmt.Fprintf(b, "Title%d=%s\n", n, oneLine(d))
+		}
+		secs := -1
+		if e.Duration > 0 {
+			secs = int(e.Duration.Round(time.Second) / time.Second)
+		}
+		fmt.Fprintf(b, "Length%d=%d\n", n, secs)
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\n", len(entries))
+	fmt.Fprintln(b, "Version=2")
+	return b.Flush()
+}
@@ -0,0 +1,67 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Title    string `xml:"title,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	// Duration is in milliseconds.
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+func decodeXSPF(r io.Reader) ([]Entry, error) {
+	var p xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, t := range p.Tracks {
+		entries = append(entries, Entry{
+			Location: t.Location,
+			Artist:   t.Creator,
+			Title:    t.Title,
+			Album:    t.Album,
+			Duration: time.Duration(t.Duration) * time.Millisecond,
+		})
+	}
+	return entries, nil
+}
+
+func encodeXSPF(w io.Writer, title string, entries []Entry) error {
+	p := xspfPlaylist{
+		Version: "1",
+		Title:   title,
+	}
+	for _, e := range entries {
+		p.Tracks = append(p.Tracks, xspfTrack{
+			Location: fileURL(e.Location),
+			Creator:  e.Artist,
+			Title:    e.Title,
+			Album:    e.Album,
+			Duration: int64(e.Duration / time.Millisecond),
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(p); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
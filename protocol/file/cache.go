@@ -0,0 +1,194 @@
+package file
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+const cacheBucket = "file-metadata"
+
+// cacheVersion must be changed whenever codec.SongInfo or the way codecs
+// read metadata changes. The cache is dropped if its version differs.
+const cacheVersion = "2"
+
+// cacheVersionKey holds the cache version. It sorts before all paths.
+var cacheVersionKey = []byte("\x00version")
+
+// cacheEntry is the cached metadata of one file. It is valid as long as the
+// file's size and modification time are unchanged.
+type cacheEntry struct {
+	Size    int64
+	ModTime int64
+	Songs   map[codec.ID]*codec.SongInfo
+	// Codec and Err are set if some songs of the file could not be read.
+	Codec string
+	Err   string
+}
+
+// cache is a persistent metadata cache keyed by file path. Lookups are
+// served from a snapshot loaded at creation, and writes are batched until
+// flush.
+type cache struct {
+	db      *bolt.DB
+	entries map[string]*cacheEntry
+	puts    map[string]*cacheEntry
+	deletes map[string]bool
+	// trees are the deleted directories, whose entries are all removed.
+	trees map[string]bool
+}
+
+// newCache returns an empty cache. It is disabled if there is no database.
+func newCache() *cache {
+	return &cache{
+		db:      protocol.DB(),
+		entries: make(map[string]*cacheEntry),
+		puts:    make(map[string]*cacheEntry),
+		deletes: make(map[string]bool),
+		trees:   make(map[string]bool),
+	}
+}
+
+// load loads all cached entries for files under root. A cache of another
+// version is dropped.
+func (c *cache) load(root string) error {
+	if c.db == nil {
+		return nil
+	}
+	var stale bool
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cacheBucket))
+		stale = b != nil && string(b.Get(cacheVersionKey)) != cacheVersion
+		return nil
+	}); err != nil {
+		return err
+	}
+	if stale {
+		if err := c.db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte(cacheBucket))
+		}); err != nil {
+			return err
+		}
+	}
+	return c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(cacheBucket))
+		if b == nil {
+			return nil
+		}
+		cur := b.Cursor()
+		prefix := []byte(root + string(filepath.Separator))
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			var e cacheEntry
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e); err != nil {
+				continue
+			}
+			c.entries[string(k)] = &e
+		}
+		return nil
+	})
+}
+
+// get returns the cached entry of path if its size and modification time
+// match fi.
+func (c *cache) get(path string, fi os.FileInfo) *cacheEntry {
+	e := c.entries[path]
+	if e == nil || e.Size != fi.Size() || e.ModTime != fi.ModTime().UnixNano() {
+		return nil
+	}
+	return e
+}
+
+func (c *cache) put(path string, fi os.FileInfo, songs map[codec.ID]*codec.SongInfo, codecName string, err error) {
+	e := &cacheEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Songs:   songs,
+		Codec:   codecName,
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	c.puts[path] = e
+}
+
+// remove deletes the entry for path.
+func (c *cache) remove(path string) {
+	delete(c.puts, path)
+	c.deletes[path] = true
+}
+
+// removeTree deletes the entry for path and, if it was a directory, the
+// entries of all files under it.
+func (c *cache) removeTree(path string) {
+	c.remove(path)
+	prefix := path + string(filepath.Separator)
+	for p := range c.puts {
+		if strings.HasPrefix(p, prefix) {
+			delete(c.puts, p)
+		}
+	}
+	c.trees[prefix] = true
+}
+
+// unseen returns the paths of loaded entries not in seen.
+func (c *cache) unseen(seen map[string]bool) []string {
+	var paths []string
+	for path := range c.entries {
+		if !seen[path] {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// flush writes pending puts and removals.
+func (c *cache) flush() error {
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(cacheBucket))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(cacheVersionKey, []byte(cacheVersion)); err != nil {
+			return err
+		}
+		for path, e := range c.puts {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+				return err
+			}
+			if err := b.Put([]byte(path), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		for path := range c.deletes {
+			if err := b.Delete([]byte(path)); err != nil {
+				return err
+			}
+		}
+		for prefix := range c.trees {
+			var keys [][]byte
+			cur := b.Cursor()
+			for k, _ := cur.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cur.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		c.puts = make(map[string]*cacheEntry)
+		c.deletes = make(map[string]bool)
+		c.trees = make(map[string]bool)
+		return nil
+	})
+}
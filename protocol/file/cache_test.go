@@ -0,0 +1,86 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mjibson/moggio/protocol"
+)
+
+type fileInfo struct {
+	os.FileInfo
+}
+
+func (fileInfo) Size() int64        { return 1 }
+func (fileInfo) ModTime() time.Time { return time.Unix(1, 0) }
+
+func TestCacheRemoveTree(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bolt.Open(filepath.Join(dir, "db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	protocol.SetDB(db)
+	defer protocol.SetDB(nil)
+
+	root := filepath.Join(dir, "music")
+	paths := []string{
+		filepath.Join(root, "a", "1.mp3"),
+		filepath.Join(root, "a", "b", "2.mp3"),
+		filepath.Join(root, "ab", "3.mp3"),
+	}
+	c := newCache()
+	for _, p := range paths {
+		c.put(p, fileInfo{}, nil, "mp3", nil)
+	}
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	c = newCache()
+	c.removeTree(filepath.Join(root, "a"))
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	c = newCache()
+	if err := c.load(root); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 || c.entries[paths[2]] == nil {
+		t.Fatalf("got entries %v, want only %s", c.entries, paths[2])
+	}
+}
+
+func TestCacheVersion(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bolt.Open(filepath.Join(dir, "db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	protocol.SetDB(db)
+	defer protocol.SetDB(nil)
+
+	root := filepath.Join(dir, "music")
+	path := filepath.Join(root, "1.mp3")
+	c := newCache()
+	c.put(path, fileInfo{}, nil, "mp3", nil)
+	if err := c.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).Put(cacheVersionKey, []byte("old"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c = newCache()
+	if err := c.load(root); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 {
+		t.Fatalf("got entries %v from an old cache", c.entries)
+	}
+}
@@ -1,13 +1,16 @@
 package file
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/mjibson/moggio/codec"
 	"github.com/mjibson/moggio/protocol"
@@ -41,6 +44,14 @@ func New(params []string, token *oauth2.Token) (protocol.Instance, error) {
 type File struct {
 	Path  string
 	Songs protocol.SongList
+	// Errors are the files that could not be read during the last refresh,
+	// sorted by path.
+	Errors []protocol.ScanError
+}
+
+// ScanErrors implements protocol.ErrorReporter.
+func (f *File) ScanErrors() []protocol.ScanError {
+	return f.Errors
 }
 
 func (f *File) Key() string {
@@ -73,41 +84,95 @@ func (f *File) List() (protocol.SongList, error) {
 }
 
 func (f *File) Refresh() (protocol.SongList, error) {
-	songs := make(protocol.SongList)
-	err := filepath.Walk(f.Path, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	songs, apply, err := f.Scan(context.Background(), nil)
+	if apply != nil {
+		apply()
+	}
+	return songs, err
+}
+
+// ignoredExts are extensions of files often found next to music. They are
+// not reported as unsupported formats.
+var ignoredExts = map[string]bool{
+	"":         true,
+	".accurip": true,
+	".bmp":     true,
+	".cue":     true,
+	".db":      true,
+	".gif":     true,
+	".ini":     true,
+	".jpeg":    true,
+	".jpg":     true,
+	".log":     true,
+	".m3u":     true,
+	".m3u8":    true,
+	".md5":     true,
+	".nfo":     true,
+	".pdf":     true,
+	".pls":     true,
+	".png":     true,
+	".sfv":     true,
+	".txt":     true,
+	".xspf":    true,
+}
+
+var errUnsupported = errors.New("unsupported format")
+
+// readFile decodes the songs in the file at path and returns them and the
+// name of their codec. Files that are not music return no songs and no error.
+// If some songs could not be read, they are returned with an error.
+func readFile(path string, fi os.FileInfo, arts *folderArt) (map[codec.ID]*codec.SongInfo, string, error) {
+	if !codec.HasExtension(path) {
+		ext := strings.ToLower(filepath.Ext(path))
+		if ignoredExts[ext] || strings.HasPrefix(filepath.Base(path), ".") {
+			return nil, "", nil
 		}
-		if info.IsDir() {
-			return nil
+		return nil, "", errUnsupported
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	ss, name, err := codec.ByExtension(path, fileReader(path))
+	if err != nil {
+		return nil, name, err
+	}
+	if len(ss) == 0 {
+		return nil, name, nil
+	}
+	songs := make(map[codec.ID]*codec.SongInfo)
+	var infoErr error
+	for i, s := range ss {
+		info, err := s.Info()
+		if err != nil && infoErr == nil {
+			infoErr = err
 		}
-		f, err := os.Open(path)
-		if err != nil {
-			return nil
+		if info.Codec == "" {
+			info.Codec = name
 		}
-		defer f.Close()
-		ss, _, err := codec.ByExtension(path, fileReader(path))
-		if err != nil || len(ss) == 0 {
-			return nil
+		info.FileSize = fi.Size()
+		// Compute the average bitrate for single song files if the codec
+		// didn't know it.
+		if info.Bitrate == 0 && len(ss) == 1 && info.Time > 0 {
+			info.Bitrate = int(float64(info.FileSize*8) / info.Time.Seconds())
 		}
-		for i, s := range ss {
-			info, _ := s.Info()
-			if info.Title == "" {
-				title := filepath.Base(path)
-				if len(ss) != 1 {
-					title += fmt.Sprintf(":%v", i)
-				}
-				info.Title = title
+		if info.Title == "" {
+			title := filepath.Base(path)
+			if len(ss) != 1 {
+				title += fmt.Sprintf(":%v", i)
 			}
-			if info.Album == "" {
-				info.Album = filepath.Base(filepath.Dir(path))
-			}
-			songs[codec.NewID(path, string(i))] = &info
+			info.Title = title
 		}
-		return nil
-	})
-	f.Songs = songs
-	return songs, err
+		if info.Album == "" {
+			info.Album = filepath.Base(filepath.Dir(path))
+		}
+		if info.ImageURL == "" {
+			info.ImageURL = arts.get(filepath.Dir(path))
+		}
+		songs[i] = &info
+	}
+	return songs, name, infoErr
 }
 
 func fileReader(path string) codec.Reader {
@@ -0,0 +1,205 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mjibson/moggio/art"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+// progressInterval is the minimum time between progress reports.
+const progressInterval = time.Second / 4
+
+// scanWorkers returns the number of files decoded concurrently.
+func scanWorkers() int {
+	n := runtime.NumCPU()
+	if n < 2 {
+		n = 2
+	}
+	if n > 16 {
+		n = 16
+	}
+	return n
+}
+
+// Scan implements protocol.Scanner. The tree is walked by one goroutine
+// while a pool of workers decodes the files not in the metadata cache. The
+// returned apply function sets f.Songs and f.Errors.
+func (f *File) Scan(ctx context.Context, progress func(protocol.Progress)) (protocol.SongList, func(), error) {
+	c := newCache()
+	if err := c.load(f.Path); err != nil {
+		log.Println(err)
+	}
+	type result struct {
+		path   string
+		fi     os.FileInfo
+		songs  map[codec.ID]*codec.SongInfo
+		codec  string
+		err    error
+		cached bool
+	}
+	type job struct {
+		path string
+		fi   os.FileInfo
+	}
+	jobs := make(chan job)
+	results := make(chan result)
+	arts := newFolderArt()
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				songs, name, err := readFile(j.path, j.fi, arts)
+				results <- result{path: j.path, fi: j.fi, songs: songs, codec: name, err: err}
+			}
+		}()
+	}
+	var p protocol.Progress
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	stored := make(map[string]bool)
+	var walkErr error
+	go func() {
+		walkErr = filepath.Walk(f.Path, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			seen[path] = true
+			mu.Lock()
+			p.Seen++
+			mu.Unlock()
+			if e := c.get(path, fi); e != nil && artStored(e.Songs, stored) {
+				r := result{path: path, songs: e.Songs, codec: e.Codec, cached: true}
+				if e.Err != "" {
+					r.err = errors.New(e.Err)
+				}
+				results <- r
+				return nil
+			}
+			jobs <- job{path, fi}
+			return nil
+		})
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+	songs := make(protocol.SongList)
+	var errs []protocol.ScanError
+	var last time.Time
+	report := func(force bool) {
+		if progress == nil || !force && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		mu.Lock()
+		cp := p
+		mu.Unlock()
+		progress(cp)
+	}
+	for r := range results {
+		mu.Lock()
+		p.Parsed++
+		p.Path = r.path
+		if r.err != nil {
+			p.Errors++
+		}
+		mu.Unlock()
+		report(false)
+		if r.err != nil {
+			errs = append(errs, protocol.ScanError{
+				Path:  r.path,
+				Codec: r.codec,
+				Error: r.err.Error(),
+			})
+		}
+		// Files that failed completely are not cached so they are retried.
+		if !r.cached && (r.err == nil || r.songs != nil) {
+			c.put(r.path, r.fi, r.songs, r.codec, r.err)
+		}
+		for i, info := range r.songs {
+			songs[codec.NewID(r.path, string(i))] = info
+		}
+	}
+	report(true)
+	// Don't remove the cache entries of unwalked files if the walk stopped
+	// early.
+	if walkErr == nil {
+		for _, path := range c.unseen(seen) {
+			c.remove(path)
+		}
+	}
+	if err := c.flush(); err != nil {
+		log.Println(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	apply := func() {
+		f.Songs = songs
+		f.Errors = errs
+	}
+	return songs, apply, walkErr
+}
+
+// artStored reports whether the art of songs is in the art cache. Art can be
+// pruned while cache entries referring to it remain, for example when an
+// instance is removed and added again, so such files are read again to store
+// their art. Found hashes are added to stored.
+func artStored(songs map[codec.ID]*codec.SongInfo, stored map[string]bool) bool {
+	for _, info := range songs {
+		h := art.Hash(info.ImageURL)
+		if h == "" || stored[h] {
+			continue
+		}
+		if ok, err := art.Default.Has(h); err != nil || !ok {
+			return false
+		}
+		stored[h] = true
+	}
+	return true
+}
+
+// folderArt caches folder cover art URLs by directory. It is safe for
+// concurrent use.
+type folderArt struct {
+	sync.Mutex
+	urls map[string]string
+}
+
+func newFolderArt() *folderArt {
+	return &folderArt{urls: make(map[string]string)}
+}
+
+func (a *folderArt) get(dir string) string {
+	a.Lock()
+	u, ok := a.urls[dir]
+	a.Unlock()
+	if ok {
+		return u
+	}
+	u = art.Folder(dir)
+	a.Lock()
+	a.urls[dir] = u
+	a.Unlock()
+	return u
+}
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/mjibson/moggio/art"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+// testSong is a silent song of the "tst" codec.
+type testSong struct{}
+
+func (testSong) Info() (codec.SongInfo, error)           { return codec.SongInfo{Title: "Song"}, nil }
+func (testSong) Init() (int, int, error)                 { return 44100, 2, nil }
+func (testSong) Play(n int) ([]float32, error)           { return nil, nil }
+func (testSong) Close()                                  {}
+func decodeTest(codec.Reader) (codec.Songs, error)       { return codec.Songs{codec.None: testSong{}}, nil }
+func getTest(codec.Reader, codec.ID) (codec.Song, error) { return testSong{}, nil }
+
+func init() {
+	codec.RegisterCodec("test", nil, []string{"tst"}, decodeTest, getTest)
+}
+
+func TestScanPrunedArt(t *testing.T) {
+	dir := t.TempDir()
+	db, err := bolt.Open(filepath.Join(dir, "db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	protocol.SetDB(db)
+	defer protocol.SetDB(nil)
+	cache := art.New()
+	if err := cache.SetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	defer func(c *art.Cache) { art.Default = c }(art.Default)
+	art.Default = cache
+
+	root := filepath.Join(dir, "music")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "song.tst"), []byte("song"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "cover.png"), []byte("\x89PNG cover"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := &File{Path: root}
+	scan := func() string {
+		songs, _, err := f.Scan(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(songs) != 1 {
+			t.Fatalf("got %d songs, want 1", len(songs))
+		}
+		for _, info := range songs {
+			return info.ImageURL
+		}
+		return ""
+	}
+	u := scan()
+	if art.Hash(u) == "" {
+		t.Fatalf("got image URL %q, want art", u)
+	}
+	// Removing the instance prunes its art, but not its metadata cache
+	// entries.
+	if _, err := cache.Prune(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := scan(); got != u {
+		t.Errorf("got image URL %q after prune, want %q", got, u)
+	}
+	if ok, err := cache.Has(art.Hash(u)); err != nil || !ok {
+		t.Errorf("art not stored after rescan: %v", err)
+	}
+}
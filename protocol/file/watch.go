@@ -0,0 +1,159 @@
+package file
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/protocol"
+)
+
+const (
+	// watchQuiet is how long no events must arrive before changes are
+	// applied, so that bulk copies are applied at once.
+	watchQuiet = time.Second * 2
+	// watchMaxDelay bounds how long changes are delayed during a
+	// continuous stream of events.
+	watchMaxDelay = time.Second * 30
+)
+
+// Watch implements protocol.Watcher. It watches all directories under f.Path
+// and re-reads created, modified, moved and deleted files.
+func (f *File) Watch(stop <-chan struct{}, update func(apply func())) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	addDirs := func(root string) {
+		filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if err := w.Add(path); err != nil {
+				log.Println("file watch:", err)
+			}
+			return nil
+		})
+	}
+	addDirs(f.Path)
+	// dirty is the set of paths that have changed since the last update.
+	dirty := make(map[string]bool)
+	var quiet, max <-chan time.Time
+	flush := func() {
+		quiet, max = nil, nil
+		update(f.rescan(dirty))
+		dirty = make(map[string]bool)
+	}
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-w.Errors:
+			log.Println("file watch:", err)
+		case ev := <-w.Events:
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			fi, err := os.Stat(ev.Name)
+			isDir := err == nil && fi.IsDir()
+			if isDir && ev.Op&fsnotify.Create != 0 {
+				addDirs(ev.Name)
+			}
+			// Removed paths may have been directories, so only existing
+			// files can be ignored by extension.
+			if err == nil && !isDir && !codec.HasExtension(ev.Name) {
+				continue
+			}
+			dirty[ev.Name] = true
+			quiet = time.After(watchQuiet)
+			if max == nil {
+				max = time.After(watchMaxDelay)
+			}
+		case <-quiet:
+			flush()
+		case <-max:
+			flush()
+		}
+	}
+}
+
+// rescan re-reads the songs at or under the dirty paths. It returns a
+// function that replaces those songs in f.Songs.
+func (f *File) rescan(dirty map[string]bool) func() {
+	c := newCache()
+	added := make(protocol.SongList)
+	arts := newFolderArt()
+	var errs []protocol.ScanError
+	for path := range dirty {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			// path may have been a directory.
+			c.removeTree(path)
+			continue
+		}
+		filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return nil
+			}
+			ss, name, err := readFile(path, fi, arts)
+			if err != nil {
+				errs = append(errs, protocol.ScanError{
+					Path:  path,
+					Codec: name,
+					Error: err.Error(),
+				})
+			}
+			if err == nil || ss != nil {
+				c.put(path, fi, ss, name, err)
+			}
+			for i, info := range ss {
+				added[codec.NewID(path, string(i))] = info
+			}
+			return nil
+		})
+	}
+	if err := c.flush(); err != nil {
+		log.Println(err)
+	}
+	return func() {
+		songs := make(protocol.SongList, len(f.Songs))
+		for id, info := range f.Songs {
+			if !under(id.Top(), dirty) {
+				songs[id] = info
+			}
+		}
+		for id, info := range added {
+			songs[id] = info
+		}
+		f.Songs = songs
+		var scanErrs []protocol.ScanError
+		for _, e := range f.Errors {
+			if !under(e.Path, dirty) {
+				scanErrs = append(scanErrs, e)
+			}
+		}
+		scanErrs = append(scanErrs, errs...)
+		sort.Slice(scanErrs, func(i, j int) bool {
+			return scanErrs[i].Path < scanErrs[j].Path
+		})
+		f.Errors = scanErrs
+	}
+}
+
+// under reports whether path or one of its parent directories is in paths.
+func under(path string, paths map[string]bool) bool {
+	for {
+		if paths[path] {
+			return true
+		}
+		dir := filepath.Dir(path)
+		if dir == path {
+			return false
+		}
+		path = dir
+	}
+}
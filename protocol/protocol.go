@@ -1,11 +1,13 @@
 package protocol
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"io"
 	"reflect"
 
+	"github.com/boltdb/bolt"
 	"github.com/mjibson/moggio/codec"
 	"golang.org/x/oauth2"
 )
@@ -35,6 +37,52 @@ type Instance interface {
 	GetSong(codec.ID) (codec.Song, error)
 }
 
+// Watcher is implemented by instances that can notice changes to their songs
+// without a full Refresh.
+type Watcher interface {
+	// Watch watches for changes until stop is closed. After each batch of
+	// changes, update is called with a function that applies them to the
+	// song list. apply must be called from the goroutine that owns the
+	// instance.
+	Watch(stop <-chan struct{}, update func(apply func())) error
+}
+
+// Progress describes a running Scan.
+type Progress struct {
+	// Seen is the number of files found so far.
+	Seen int
+	// Parsed is the number of files read, including failed ones.
+	Parsed int
+	// Errors is the number of files that could not be read.
+	Errors int
+	// Path is the most recently parsed file.
+	Path string
+}
+
+// Scanner is implemented by instances that can report progress while
+// refreshing.
+type Scanner interface {
+	// Scan is like Refresh but calls progress periodically, and stops early
+	// with ctx's error if ctx is cancelled. Since the instance may be in use
+	// during the scan, Scan does not change it: apply stores the songs, and
+	// must be called by the user of the instance.
+	Scan(ctx context.Context, progress func(Progress)) (songs SongList, apply func(), err error)
+}
+
+// ScanError describes a file that could not be read during a refresh.
+type ScanError struct {
+	Path string
+	// Codec is the codec chosen for the file, if any.
+	Codec string `json:",omitempty"`
+	Error string
+}
+
+// ErrorReporter is implemented by instances that keep the per-file errors
+// of their last refresh.
+type ErrorReporter interface {
+	ScanErrors() []ScanError
+}
+
 type SongList map[codec.ID]*codec.SongInfo
 
 func (p *Protocol) NewInstance(params []string, token *oauth2.Token) (Instance, error) {
@@ -55,6 +103,19 @@ func (p *Protocol) Decode(r io.Reader) (Instance, error) {
 
 var protocols = make(map[string]*Protocol)
 
+var db *bolt.DB
+
+// SetDB sets the database protocols may use for persistent caches. It is
+// called by the server before any instance is used.
+func SetDB(d *bolt.DB) {
+	db = d
+}
+
+// DB returns the database set by SetDB, or nil if none was set.
+func DB() *bolt.DB {
+	return db
+}
+
 func Register(name string, params []string, newInstance func([]string, *oauth2.Token) (Instance, error), instType reflect.Type) {
 	protocols[name] = &Protocol{
 		Params: &Params{
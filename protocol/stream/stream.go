@@ -165,8 +165,7 @@ var client = &http.Client{
 func (s *Stream) get() (*http.Response, error) {
 	req, err := http.NewRequest("GET", s.URL, nil)
 	if err != nil {
-		panic(err)
-		log.Fatal(err)
+		return nil, err
 	}
 	req.Header.Add("Icy-MetaData", "1")
 	log.Println("stream open", req.URL)
@@ -0,0 +1,118 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LastFMURL is the public Last.fm API.
+const LastFMURL = "https://ws.audioscrobbler.com/2.0/"
+
+// LastFM submits listens with the Last.fm API, which is also implemented by
+// services like Libre.fm.
+type LastFM struct {
+	// URL is the API endpoint. If empty, LastFMURL is used.
+	URL        string
+	APIKey     string
+	Secret     string
+	SessionKey string
+}
+
+func (lf *LastFM) NowPlaying(ctx context.Context, l Listen) error {
+	v := url.Values{}
+	v.Set("artist", l.Artist)
+	v.Set("track", l.Title)
+	if l.Album != "" {
+		v.Set("album", l.Album)
+	}
+	if l.Duration > 0 {
+		v.Set("duration", strconv.Itoa(int(l.Duration.Seconds())))
+	}
+	return lf.call(ctx, "track.updateNowPlaying", v)
+}
+
+func (lf *LastFM) Submit(ctx context.Context, ls []Listen) error {
+	v := url.Values{}
+	for i, l := range ls {
+		n := fmt.Sprintf("[%d]", i)
+		v.Set("artist"+n, l.Artist)
+		v.Set("track"+n, l.Title)
+		v.Set("timestamp"+n, strconv.FormatInt(l.Start.Unix(), 10))
+		if l.Album != "" {
+			v.Set("album"+n, l.Album)
+		}
+		if l.Duration > 0 {
+			v.Set("duration"+n, strconv.Itoa(int(l.Duration.Seconds())))
+		}
+	}
+	return lf.call(ctx, "track.scrobble", v)
+}
+
+// call calls the API method with the signed parameters v.
+func (lf *LastFM) call(ctx context.Context, method string, v url.Values) error {
+	v.Set("method", method)
+	v.Set("api_key", lf.APIKey)
+	v.Set("sk", lf.SessionKey)
+	v.Set("api_sig", lf.sign(v))
+	v.Set("format", "json")
+	u := lf.URL
+	if u == "" {
+		u = LastFMURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPError{resp.StatusCode, string(b)}
+	}
+	var r struct {
+		Error   int
+		Message string
+	}
+	if json.Unmarshal(b, &r) == nil && r.Error != 0 {
+		// Error 11 and 16 are temporary service failures.
+		status := http.StatusBadRequest
+		if r.Error == 11 || r.Error == 16 {
+			status = http.StatusServiceUnavailable
+		}
+		return &HTTPError{status, fmt.Sprintf("lastfm error %d: %s", r.Error, r.Message)}
+	}
+	return nil
+}
+
+// sign returns the signature of v: the MD5 of the sorted names and values
+// followed by the secret.
+func (lf *LastFM) sign(v url.Values) string {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteString(v.Get(k))
+	}
+	b.WriteString(lf.Secret)
+	sum := md5.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package scrobble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestLastFMSign(t *testing.T) {
+	lf := &LastFM{Secret: "secret"}
+	v := url.Values{
+		"method":  {"track.updateNowPlaying"},
+		"api_key": {"key"},
+		"sk":      {"session"},
+		"artist":  {"Cher"},
+		"track":   {"Believe"},
+		// format and callback are not signed.
+		"format":   {"json"},
+		"callback": {"f"},
+	}
+	const want = "a689966a1961f646b843d3a6bdff3cf8"
+	if got := lf.sign(v); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+}
+
+func TestLastFMSubmit(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"scrobbles":{}}`))
+	}))
+	defer ts.Close()
+	lf := &LastFM{
+		URL:        ts.URL,
+		APIKey:     "key",
+		Secret:     "secret",
+		SessionKey: "session",
+	}
+	err := lf.Submit(context.Background(), []Listen{{
+		Artist: "Cher",
+		Title:  "Believe",
+		Album:  "Believe",
+		Start:  time.Unix(946684800, 0),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "91ebf43c72ad322a002d0e94dae0d0ff"
+	if got := form.Get("api_sig"); got != want {
+		t.Errorf("got signature %s, want %s", got, want)
+	}
+	if got := form.Get("format"); got != "json" {
+		t.Errorf("got format %q, want json", got)
+	}
+}
+
+func TestLastFMError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error":9,"message":"Invalid session key"}`))
+	}))
+	defer ts.Close()
+	lf := &LastFM{URL: ts.URL}
+	err := lf.NowPlaying(context.Background(), Listen{Artist: "Cher", Title: "Believe"})
+	if !permanent(err) {
+		t.Errorf("got %v, want a permanent error", err)
+	}
+}
@@ -0,0 +1,92 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ListenBrainzURL is the public ListenBrainz API.
+const ListenBrainzURL = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens with the ListenBrainz API.
+type ListenBrainz struct {
+	// URL is the base URL of the API. If empty, ListenBrainzURL is used.
+	URL   string
+	Token string
+}
+
+type lbPayload struct {
+	ListenedAt int64 `json:"listened_at,omitempty"`
+	Track      struct {
+		Artist     string `json:"artist_name"`
+		Track      string `json:"track_name"`
+		Release    string `json:"release_name,omitempty"`
+		Additional struct {
+			DurationMS int64  `json:"duration_ms,omitempty"`
+			Player     string `json:"media_player"`
+		} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+func (lb *ListenBrainz) NowPlaying(ctx context.Context, l Listen) error {
+	p := lbListen(l)
+	p.ListenedAt = 0
+	return lb.submit(ctx, "playing_now", []lbPayload{p})
+}
+
+func (lb *ListenBrainz) Submit(ctx context.Context, ls []Listen) error {
+	typ := "import"
+	if len(ls) == 1 {
+		typ = "single"
+	}
+	ps := make([]lbPayload, len(ls))
+	for i, l := range ls {
+		ps[i] = lbListen(l)
+	}
+	return lb.submit(ctx, typ, ps)
+}
+
+func lbListen(l Listen) lbPayload {
+	var p lbPayload
+	p.ListenedAt = l.Start.Unix()
+	p.Track.Artist = l.Artist
+	p.Track.Track = l.Title
+	p.Track.Release = l.Album
+	p.Track.Additional.DurationMS = l.Duration.Milliseconds()
+	p.Track.Additional.Player = "moggio"
+	return p
+}
+
+func (lb *ListenBrainz) submit(ctx context.Context, typ string, ps []lbPayload) error {
+	body, err := json.Marshal(struct {
+		Type    string      `json:"listen_type"`
+		Payload []lbPayload `json:"payload"`
+	}{typ, ps})
+	if err != nil {
+		return err
+	}
+	u := lb.URL
+	if u == "" {
+		u = ListenBrainzURL
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(u, "/")+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+lb.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &HTTPError{resp.StatusCode, string(b)}
+	}
+	return nil
+}
@@ -0,0 +1,302 @@
+// Package scrobble submits played songs to ListenBrainz and Last.fm
+// compatible services. Failed submissions are queued in bolt and retried.
+package scrobble
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// A Listen is a played song.
+type Listen struct {
+	Artist   string
+	Title    string
+	Album    string
+	Duration time.Duration
+	Start    time.Time
+}
+
+// A Service submits listens.
+type Service interface {
+	// NowPlaying reports that l has started playing.
+	NowPlaying(ctx context.Context, l Listen) error
+	// Submit submits at most MaxBatch listens.
+	Submit(ctx context.Context, ls []Listen) error
+}
+
+// MaxBatch is the most listens submitted at once.
+const MaxBatch = 50
+
+// minDuration is the shortest song that can be scrobbled.
+const minDuration = 30 * time.Second
+
+// Eligible reports whether a song of duration that was listened to for
+// listened should be scrobbled: songs longer than 30 seconds that were played
+// for half their duration or 4 minutes. If duration is unknown, only the 4
+// minute rule applies.
+func Eligible(listened, duration time.Duration) bool {
+	if duration > 0 && duration <= minDuration {
+		return false
+	}
+	return listened >= 4*time.Minute || (duration > 0 && listened >= duration/2)
+}
+
+// Config configures a Service.
+type Config struct {
+	// Service is "listenbrainz" or "lastfm".
+	Service string
+	// URL is the base URL of the API. If empty, the service's public API is
+	// used.
+	URL string `json:",omitempty"`
+	// Token is the ListenBrainz user token.
+	Token string `json:",omitempty"`
+	// APIKey, Secret and SessionKey authenticate with Last.fm.
+	APIKey     string `json:",omitempty"`
+	Secret     string `json:",omitempty"`
+	SessionKey string `json:",omitempty"`
+}
+
+// NewService returns the service configured by c.
+func (c *Config) NewService() (Service, error) {
+	switch c.Service {
+	case "listenbrainz":
+		if c.Token == "" {
+			return nil, fmt.Errorf("listenbrainz: missing token")
+		}
+		return &ListenBrainz{
+			URL:   c.URL,
+			Token: c.Token,
+		}, nil
+	case "lastfm":
+		if c.APIKey == "" || c.Secret == "" || c.SessionKey == "" {
+			return nil, fmt.Errorf("lastfm: missing api key, secret or session key")
+		}
+		return &LastFM{
+			URL:        c.URL,
+			APIKey:     c.APIKey,
+			Secret:     c.Secret,
+			SessionKey: c.SessionKey,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown scrobble service: %s", c.Service)
+}
+
+// An HTTPError is an unsuccessful response from a service.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("scrobble: %d: %s", e.StatusCode, e.Body)
+}
+
+// permanent reports whether retrying err can't succeed.
+func permanent(err error) bool {
+	e, ok := err.(*HTTPError)
+	return ok && e.StatusCode >= 400 && e.StatusCode < 500 && e.StatusCode != http.StatusTooManyRequests
+}
+
+const bucket = "scrobble"
+
+const (
+	retryMin = 30 * time.Second
+	retryMax = 30 * time.Minute
+	timeout  = 30 * time.Second
+)
+
+// A Scrobbler submits listens to a Service in the background. Listens are
+// stored in bolt until they are submitted.
+type Scrobbler struct {
+	svc   Service
+	db    *bolt.DB
+	flush chan struct{}
+	// ctx is canceled by Close to stop s and abort its requests.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	playing chan Listen
+}
+
+// New returns a Scrobbler that submits to svc, and submits listens queued
+// by earlier Scrobblers.
+func New(db *bolt.DB, svc Service) *Scrobbler {
+	s := &Scrobbler{
+		svc:     svc,
+		db:      db,
+		flush:   make(chan struct{}, 1),
+		playing: make(chan Listen, 1),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(2)
+	go s.submit()
+	go s.nowPlaying()
+	s.signal()
+	return s
+}
+
+// Close stops s and aborts its requests. Queued listens remain stored.
+func (s *Scrobbler) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// NowPlaying reports l as playing. It doesn't block, and a report is dropped
+// if it is replaced by a newer one before being sent.
+func (s *Scrobbler) NowPlaying(l Listen) {
+	for {
+		select {
+		case s.playing <- l:
+			return
+		default:
+		}
+		select {
+		case <-s.playing:
+		default:
+		}
+	}
+}
+
+// Scrobble queues l for submission.
+func (s *Scrobbler) Scrobble(l Listen) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(l.Start.UnixNano()))
+		for b.Get(key) != nil {
+			binary.BigEndian.PutUint64(key, binary.BigEndian.Uint64(key)+1)
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		return err
+	}
+	s.signal()
+	return nil
+}
+
+func (s *Scrobbler) signal() {
+	select {
+	case s.flush <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scrobbler) nowPlaying() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case l := <-s.playing:
+			ctx, cancel := context.WithTimeout(s.ctx, timeout)
+			if err := s.svc.NowPlaying(ctx, l); err != nil {
+				log.Println("scrobble: now playing:", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// queued returns the oldest queued listens and their keys.
+func (s *Scrobbler) queued() (keys [][]byte, ls []Listen, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && len(ls) < MaxBatch; k, v = c.Next() {
+			var l Listen
+			if err := json.Unmarshal(v, &l); err != nil {
+				log.Println("scrobble: dropping bad listen:", err)
+			} else {
+				ls = append(ls, l)
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	return
+}
+
+func (s *Scrobbler) remove(keys [][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// submit submits queued listens whenever signaled, retrying with
+// exponential backoff on failure.
+func (s *Scrobbler) submit() {
+	defer s.wg.Done()
+	delay := retryMin
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.flush:
+		case <-retry:
+		}
+		retry = nil
+		for {
+			keys, ls, err := s.queued()
+			if err != nil {
+				log.Println("scrobble:", err)
+				break
+			}
+			if len(keys) == 0 {
+				delay = retryMin
+				break
+			}
+			if len(ls) > 0 {
+				ctx, cancel := context.WithTimeout(s.ctx, timeout)
+				err = s.svc.Submit(ctx, ls)
+				cancel()
+				if s.ctx.Err() != nil {
+					return
+				}
+			}
+			if err != nil && !permanent(err) {
+				log.Printf("scrobble: retrying in %v: %v", delay, err)
+				retry = time.After(delay)
+				if delay *= 2; delay > retryMax {
+					delay = retryMax
+				}
+				break
+			}
+			if err != nil {
+				log.Printf("scrobble: dropping %d listens: %v", len(ls), err)
+			}
+			if err := s.remove(keys); err != nil {
+				log.Println("scrobble:", err)
+				break
+			}
+		}
+	}
+}
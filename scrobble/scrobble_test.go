@@ -0,0 +1,54 @@
+package scrobble
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// blockingService blocks until its context is done.
+type blockingService chan struct{}
+
+func (b blockingService) NowPlaying(ctx context.Context, l Listen) error {
+	return b.Submit(ctx, nil)
+}
+
+func (b blockingService) Submit(ctx context.Context, ls []Listen) error {
+	b <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCloseAborts(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	svc := make(blockingService)
+	s := New(db, svc)
+	if err := s.Scrobble(Listen{Artist: "a", Title: "t", Start: time.Unix(1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	<-svc
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close waited for the request")
+	}
+	keys, _, err := s.queued()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("got %d queued listens after Close, want 1", len(keys))
+	}
+}
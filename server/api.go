@@ -0,0 +1,502 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/library"
+	"github.com/mjibson/moggio/protocol"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+// apiHandler is a handler of the v1 API.
+type apiHandler func(io.Reader, url.Values, httprouter.Params) (interface{}, error)
+
+// apiRouter returns the router of the v1 API, served at /api/v1/.
+func (srv *Server) apiRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.GET("/api/v1/openapi.json", OpenAPI)
+	router.GET("/api/v1/player", API(srv.APIPlayer))
+	router.POST("/api/v1/player/:action", API(srv.APIPlayerAction))
+	router.PATCH("/api/v1/player", API(srv.APIModes))
+	router.GET("/api/v1/queue", API(srv.APIQueue))
+	router.PATCH("/api/v1/queue", API(srv.APIQueueChange))
+	router.DELETE("/api/v1/queue", API(srv.APIQueueClear))
+	router.GET("/api/v1/playlists", API(srv.APIPlaylists))
+	router.GET("/api/v1/playlists/:playlist", API(srv.APIPlaylist))
+	router.PATCH("/api/v1/playlists/:playlist", API(srv.APIPlaylistChange))
+	router.PUT("/api/v1/playlists/:playlist/smart", API(srv.APISmart))
+	router.DELETE("/api/v1/playlists/:playlist", API(srv.APIPlaylistDelete))
+	router.GET("/api/v1/library/search", API(errStatus(http.StatusBadRequest, srv.Search)))
+	router.GET("/api/v1/library/tracks", API(errStatus(http.StatusBadRequest, srv.Tracks)))
+	router.GET("/api/v1/library/changes", API(errStatus(http.StatusBadRequest, srv.TrackChanges)))
+	router.GET("/api/v1/library/browse/:view", API(srv.Browse))
+	router.GET("/api/v1/protocols", API(srv.APIProtocols))
+	router.POST("/api/v1/protocols", API(srv.APIProtocolAdd))
+	router.POST("/api/v1/protocols/:protocol/refresh", API(srv.APIProtocolRefresh))
+	router.DELETE("/api/v1/protocols/:protocol", API(srv.APIProtocolRemove))
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveAPIError(w, notFound(fmt.Errorf("not found: %s %s", r.Method, r.URL.Path)))
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveAPIError(w, &apiError{
+			status: http.StatusMethodNotAllowed,
+			err:    fmt.Errorf("method not allowed: %s %s", r.Method, r.URL.Path),
+		})
+	})
+	return router
+}
+
+// OpenAPI serves the OpenAPI document of the v1 API.
+func OpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+// apiError is an error sent with an HTTP status code.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &apiError{status: http.StatusBadRequest, err: err}
+}
+
+func notFound(err error) error {
+	return &apiError{status: http.StatusNotFound, err: err}
+}
+
+func conflict(err error) error {
+	return &apiError{status: http.StatusConflict, err: err}
+}
+
+// errStatus returns h with its errors sent with the status code, for
+// handlers that only fail on bad requests.
+func errStatus(status int, h apiHandler) apiHandler {
+	return func(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+		v, err := h(body, form, ps)
+		if err != nil {
+			err = &apiError{status: status, err: err}
+		}
+		return v, err
+	}
+}
+
+// changeError returns the status of an error of a PlaylistChange: conflict
+// if another client changed the playlist, otherwise bad request.
+func changeError(err error) error {
+	if err == errVersion {
+		return conflict(err)
+	}
+	return badRequest(err)
+}
+
+// ErrorResponse is the body of v1 API error responses.
+type ErrorResponse struct {
+	Error struct {
+		// Status is the HTTP status code.
+		Status  int
+		Message string
+	}
+}
+
+func serveAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		status = e.status
+	}
+	if status >= 500 {
+		log.Println(err)
+	}
+	var res ErrorResponse
+	res.Error.Status = status
+	res.Error.Message = err.Error()
+	b, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// API is like JSON, but sends errors as an ErrorResponse with the status of
+// the error, and sends 204 No Content if h returns nothing.
+func API(h apiHandler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// Bodies are JSON, so only the query is parsed as the form.
+		form, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			serveAPIError(w, badRequest(err))
+			return
+		}
+		d, err := h(r.Body, form, ps)
+		if err != nil {
+			serveAPIError(w, err)
+			return
+		}
+		if d == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		b, err := json.Marshal(d)
+		if err != nil {
+			serveAPIError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}
+}
+
+// decode decodes the JSON body into v.
+func decode(body io.Reader, v interface{}) error {
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return badRequest(fmt.Errorf("bad body: %v", err))
+	}
+	return nil
+}
+
+// APIPlayer returns the Status.
+func (srv *Server) APIPlayer(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var st Status
+	srv.do(func() { st = srv.status() })
+	return st, nil
+}
+
+// APIPlayerAction runs a player action and returns the Status after it. The
+// actions are play, pause, stop, next, prev and seek. The body of play may
+// set Index, the index in the queue to play. The body of seek sets Position,
+// a duration like "1m30s"; it returns nothing since the audio seeks after
+// the response.
+func (srv *Server) APIPlayerAction(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var cmd interface{}
+	switch action := ps.ByName("action"); action {
+	case "play":
+		var req struct {
+			Index *int
+		}
+		// The body is optional.
+		if err := json.NewDecoder(body).Decode(&req); err != nil && err != io.EOF {
+			return nil, badRequest(fmt.Errorf("bad body: %v", err))
+		}
+		cmd = cmdPlay
+		if req.Index != nil {
+			var n int
+			srv.do(func() { n = len(srv.Queue) })
+			if *req.Index < 0 || *req.Index >= n {
+				return nil, badRequest(fmt.Errorf("unknown index: %d", *req.Index))
+			}
+			cmd = cmdPlayIdx(*req.Index)
+		}
+	case "pause":
+		cmd = cmdPause
+	case "stop":
+		cmd = cmdStop
+	case "next":
+		cmd = cmdNext
+	case "prev":
+		cmd = cmdPrev
+	case "seek":
+		var req struct {
+			Position string
+		}
+		if err := decode(body, &req); err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(req.Position)
+		if err != nil || d < 0 {
+			return nil, badRequest(fmt.Errorf("bad position: %q", req.Position))
+		}
+		return nil, srv.wait(cmdSeek(d))
+	default:
+		return nil, notFound(fmt.Errorf("unknown action: %s", action))
+	}
+	if err := srv.wait(cmd); err != nil {
+		return nil, err
+	}
+	return srv.APIPlayer(body, form, ps)
+}
+
+// APIModes sets the Modes in the body and returns the Status.
+func (srv *Server) APIModes(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var m Modes
+	if err := decode(body, &m); err != nil {
+		return nil, err
+	}
+	if err := srv.wait(cmdSetModes(m)); err != nil {
+		return nil, err
+	}
+	return srv.APIPlayer(body, form, ps)
+}
+
+// APIPlaylist is a playlist of the v1 API.
+type APIPlaylist struct {
+	// Version is the version to send with PlaylistChange to fail if another
+	// client has changed the playlist.
+	Version uint64
+	// Smart are the rules of a smart playlist.
+	Smart *library.Smart `json:",omitempty"`
+	Songs PlaylistInfo
+}
+
+// APIQueue returns the queue.
+func (srv *Server) APIQueue(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var p APIPlaylist
+	srv.do(func() {
+		p.Version = srv.versions.queue
+		p.Songs = srv.playlistInfo(srv.Queue)
+	})
+	return p, nil
+}
+
+// APIQueueChange applies the PlaylistChange in the body to the queue and
+// returns the queue.
+func (srv *Server) APIQueueChange(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var plc PlaylistChange
+	if err := decode(body, &plc); err != nil {
+		return nil, err
+	}
+	c := cmdQueueChange{
+		plc:  plc,
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, changeError(err)
+	}
+	return srv.APIQueue(body, form, ps)
+}
+
+// APIQueueClear removes all songs from the queue.
+func (srv *Server) APIQueueClear(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	c := cmdQueueChange{
+		plc:  PlaylistChange{{"clear"}},
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	return nil, <-c.done
+}
+
+// PlaylistSummary describes a playlist in the list of playlists.
+type PlaylistSummary struct {
+	Name  string
+	Songs int
+	Smart bool `json:",omitempty"`
+}
+
+// APIPlaylists returns the playlists, sorted by name.
+func (srv *Server) APIPlaylists(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	res := []PlaylistSummary{}
+	srv.do(func() {
+		for name, p := range srv.Playlists {
+			res = append(res, PlaylistSummary{Name: name, Songs: len(p)})
+		}
+		for name, p := range srv.smart {
+			res = append(res, PlaylistSummary{Name: name, Songs: len(p), Smart: true})
+		}
+	})
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, nil
+}
+
+// APIPlaylist returns a playlist.
+func (srv *Server) APIPlaylist(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	name := ps.ByName("playlist")
+	var p APIPlaylist
+	var err error
+	srv.do(func() {
+		var pl Playlist
+		if pl, err = srv.playlist(name); err != nil {
+			return
+		}
+		p.Version = srv.versions.playlist(name)
+		p.Songs = srv.playlistInfo(pl)
+		if smart, ok := srv.SmartPlaylists[name]; ok {
+			p.Smart = &smart
+		}
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return p, nil
+}
+
+// APIPlaylistChange applies the PlaylistChange in the body to a playlist,
+// creating it if needed, and returns the playlist. Playlists left empty are
+// removed, and nothing is returned.
+func (srv *Server) APIPlaylistChange(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	name := ps.ByName("playlist")
+	var plc PlaylistChange
+	if err := decode(body, &plc); err != nil {
+		return nil, err
+	}
+	var smart bool
+	srv.do(func() { _, smart = srv.SmartPlaylists[name] })
+	if smart {
+		return nil, conflict(fmt.Errorf("cannot change smart playlist: %s", name))
+	}
+	c := cmdPlaylistChange{
+		plc:  plc,
+		name: name,
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, changeError(err)
+	}
+	p, err := srv.APIPlaylist(body, form, ps)
+	if e, ok := err.(*apiError); ok && e.status == http.StatusNotFound {
+		return nil, nil
+	}
+	return p, err
+}
+
+// APISmart makes a playlist the smart playlist of the library.Smart in the
+// body, and returns it.
+func (srv *Server) APISmart(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	name := ps.ByName("playlist")
+	var smart library.Smart
+	if err := decode(body, &smart); err != nil {
+		return nil, err
+	}
+	if err := smart.Check(); err != nil {
+		return nil, badRequest(err)
+	}
+	c := cmdSmartChange{
+		name:  name,
+		smart: &smart,
+		done:  make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, conflict(err)
+	}
+	return srv.APIPlaylist(body, form, ps)
+}
+
+// APIPlaylistDelete removes a playlist.
+func (srv *Server) APIPlaylistDelete(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	name := ps.ByName("playlist")
+	var exists, smart bool
+	srv.do(func() {
+		_, exists = srv.Playlists[name]
+		_, smart = srv.SmartPlaylists[name]
+	})
+	var done chan error
+	switch {
+	case smart:
+		done = make(chan error, 1)
+		srv.ch <- cmdSmartChange{
+			name: name,
+			done: done,
+		}
+	case exists:
+		done = make(chan error, 1)
+		srv.ch <- cmdPlaylistChange{
+			plc:  PlaylistChange{{"clear"}},
+			name: name,
+			done: done,
+		}
+	default:
+		return nil, notFound(fmt.Errorf("unknown playlist: %s", name))
+	}
+	return nil, <-done
+}
+
+// APIProtocols returns the available protocols, the current protocol
+// instances and the instances being refreshed.
+func (srv *Server) APIProtocols(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	ch := make(chan *waitData)
+	srv.ch <- cmdWaitData{
+		wt:   waitProtocols,
+		done: ch,
+	}
+	return (<-ch).Data, nil
+}
+
+// APIProtocolAdd adds the protocol instance with the Protocol and Params in
+// the body, and returns its ProtocolData. The library is then refreshed in
+// the background.
+func (srv *Server) APIProtocolAdd(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var ap struct {
+		Protocol string
+		Params   []string
+	}
+	if err := decode(body, &ap); err != nil {
+		return nil, err
+	}
+	prot, err := protocol.ByName(ap.Protocol)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	inst, err := prot.NewInstance(ap.Params, nil)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	if err := srv.wait(cmdProtocolAdd{
+		Name:     ap.Protocol,
+		Instance: inst,
+	}); err != nil {
+		return nil, err
+	}
+	return ProtocolData{
+		Protocol: ap.Protocol,
+		Key:      inst.Key(),
+	}, nil
+}
+
+// instance returns the ProtocolData of the protocol parameter and key form
+// value, or a not found error if there is no such instance.
+func (srv *Server) instance(form url.Values, ps httprouter.Params) (ProtocolData, error) {
+	pd := ProtocolData{
+		Protocol: ps.ByName("protocol"),
+		Key:      form.Get("key"),
+	}
+	var err error
+	srv.do(func() { _, err = srv.getInstance(pd.Protocol, pd.Key) })
+	if err != nil {
+		return pd, notFound(err)
+	}
+	return pd, nil
+}
+
+// APIProtocolRefresh refreshes the protocol instance with the key
+// parameter, returning when done.
+func (srv *Server) APIProtocolRefresh(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	pd, err := srv.instance(form, ps)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan error)
+	srv.ch <- cmdProtocolRefresh{
+		protocol: pd.Protocol,
+		key:      pd.Key,
+		list:     false,
+		doDelete: true,
+		err:      ch,
+	}
+	return nil, <-ch
+}
+
+// APIProtocolRemove removes the protocol instance with the key parameter.
+func (srv *Server) APIProtocolRemove(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	return nil, srv.wait(cmdProtocolRemove{
+		protocol: ps.ByName("protocol"),
+		key:      form.Get("key"),
+	})
+}
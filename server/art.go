@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/art"
+	"golang.org/x/image/draw"
+)
+
+// maxArtSize is the largest size allowed for resized art.
+const maxArtSize = 2048
+
+// Art serves cover art from the art cache. The optional size parameter
+// scales the image to fit in a size x size square.
+func (srv *Server) Art(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	hash := ps.ByName("hash")
+	var size int
+	if s := r.FormValue("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || size <= 0 || size > maxArtSize {
+			http.Error(w, "bad size", http.StatusBadRequest)
+			return
+		}
+	}
+	if ok, err := srv.art.Has(hash); err != nil {
+		serveError(w, err)
+		return
+	} else if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	etag := fmt.Sprintf(`"%s-%d"`, hash, size)
+	w.Header().Set("ETag", etag)
+	// Art is content-addressed, so a URL's response never changes.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	mime, data, err := srv.art.Get(hash)
+	if err == art.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		serveError(w, err)
+		return
+	}
+	if size > 0 {
+		if b, m, err := resize(data, size); err == nil {
+			data, mime = b, m
+		}
+	}
+	w.Header().Set("Content-Type", mime)
+	w.Write(data)
+}
+
+// pruneArt removes the art no song of the library refers to. It should only
+// be called by the commands() function, when no refresh is in progress.
+func (srv *Server) pruneArt() {
+	keep := make(map[string]bool)
+	for _, id := range srv.library.IDs() {
+		info := srv.library.Get(id).Info
+		if info == nil {
+			continue
+		}
+		if h := art.Hash(info.ImageURL); h != "" {
+			keep[h] = true
+		}
+	}
+	n, err := srv.art.Prune(keep)
+	if err != nil {
+		printErr(err)
+	} else if n > 0 {
+		log.Printf("removed %d unused images", n)
+	}
+}
+
+// resize scales the image in b to fit in a size x size square. Images that
+// already fit are returned unchanged.
+func resize(b []byte, size int) ([]byte, string, error) {
+	src, format, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+	sb := src.Bounds()
+	dx, dy := sb.Dx(), sb.Dy()
+	if dx <= size && dy <= size {
+		return b, "image/" + format, nil
+	}
+	if dx > dy {
+		dx, dy = size, dy*size/dx
+	} else {
+		dx, dy = dx*size/dy, size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dx, dy))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, sb, draw.Src, nil)
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, dst)
+		return buf.Bytes(), "image/png", err
+	}
+	err = jpeg.Encode(&buf, dst, nil)
+	return buf.Bytes(), "image/jpeg", err
+}
@@ -14,6 +14,7 @@ func (srv *Server) audio() {
 	var t chan interface{}
 	var seek *Seek
 	var dur time.Duration
+	volume := 1.0
 	var err error
 	send := func(v interface{}) {
 		go func() {
@@ -33,6 +34,14 @@ func (srv *Server) audio() {
 		}
 		next, err := seek.Read(expected)
 		if len(next) > 0 {
+			if volume != 1 {
+				// next may be the seek buffer, so scale a copy.
+				scaled := make([]float32, len(next))
+				for i, v := range next {
+					scaled[i] = v * float32(volume)
+				}
+				next = scaled
+			}
 			out.Push(next)
 			setTime(false)
 		}
@@ -42,7 +51,7 @@ func (srv *Server) audio() {
 		if err == io.ErrUnexpectedEOF {
 			send(cmdRestartSong)
 		} else if err != nil {
-			send(cmdNext)
+			send(cmdSongEnded{})
 		}
 	}
 	doSeek := func(c cmdSeek) {
@@ -84,6 +93,8 @@ func (srv *Server) audio() {
 				setParams(c)
 			case cmdSeek:
 				doSeek(c)
+			case audioVolume:
+				volume = float64(c)
 			default:
 				panic("unknown type")
 			}
@@ -102,3 +113,6 @@ type audioSetParams struct {
 type audioStop struct{}
 
 type audioPlay struct{}
+
+// audioVolume sets the gain applied to samples, from 0 to 1.
+type audioVolume float64
@@ -3,6 +3,8 @@ package server
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,8 +13,10 @@ import (
 
 	"github.com/bradfitz/slice"
 	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/library"
 	"github.com/mjibson/moggio/models"
 	"github.com/mjibson/moggio/protocol"
+	"github.com/mjibson/moggio/scrobble"
 	"golang.org/x/net/websocket"
 	"golang.org/x/oauth2"
 )
@@ -22,15 +26,125 @@ func (srv *Server) commands(initialState State) {
 	log.Println("initial state:", initialState)
 	var next, stop, tick, play, pause, prev func()
 	var timer <-chan time.Time
-	waiters := make(map[*websocket.Conn]chan struct{})
+	waiters := make(map[*websocket.Conn]*waiter)
+	send := func(ws *websocket.Conn, wd *waitData) {
+		go func() {
+			if err := websocket.JSON.Send(ws, wd); err != nil {
+				srv.ch <- cmdDeleteWS(ws)
+			}
+		}()
+	}
+	// listeners are sent the types of data sent to clients, for MPD idle
+	// notifications.
+	listeners := make(map[cmdListen]bool)
+	notify := func(wt waitType) {
+		for l := range listeners {
+			select {
+			case <-l.done:
+				delete(listeners, l)
+				continue
+			default:
+			}
+			select {
+			case l.events <- wt:
+			default:
+			}
+		}
+	}
+	// streams are the event stream clients. backlog holds the last events
+	// sent, to resume streams.
+	streams := make(map[chan *event]cmdEvents)
+	var backlog []*event
+	var lastEvent uint64
+	sendEvent := func(c cmdEvents, e *event) bool {
+		if !c.wants(e.typ) {
+			return true
+		}
+		select {
+		case c.events <- e:
+			return true
+		default:
+			return false
+		}
+	}
+	// dropEvent counts an event that is not kept since there are no
+	// streams. Streams resuming from before it are sent the current data
+	// instead.
+	dropEvent := func() {
+		lastEvent++
+		backlog = nil
+	}
+	record := func(wd *waitData) {
+		if len(streams) == 0 {
+			dropEvent()
+			return
+		}
+		b, err := json.Marshal(wd.Data)
+		if err != nil {
+			printErr(err)
+			return
+		}
+		lastEvent++
+		e := &event{
+			id:   lastEvent,
+			typ:  wd.Type,
+			data: b,
+		}
+		if len(backlog) == eventBacklog {
+			copy(backlog, backlog[1:])
+			backlog = backlog[:len(backlog)-1]
+		}
+		backlog = append(backlog, e)
+		for ch, c := range streams {
+			select {
+			case <-c.done:
+				delete(streams, ch)
+				continue
+			default:
+			}
+			if !sendEvent(c, e) {
+				close(ch)
+				delete(streams, ch)
+			}
+		}
+	}
+	newEvents := func(c cmdEvents) {
+		streams[c.events] = c
+		if c.resume && (c.last == lastEvent || len(backlog) > 0 && backlog[0].id <= c.last+1 && c.last < lastEvent) {
+			for _, e := range backlog {
+				if e.id > c.last {
+					sendEvent(c, e)
+				}
+			}
+			return
+		}
+		for _, wt := range []waitType{
+			waitPlaylist,
+			waitProtocols,
+			waitStatus,
+			waitLibrary,
+		} {
+			if !c.wants(wt) {
+				continue
+			}
+			b, err := json.Marshal(srv.makeWaitData(wt).Data)
+			if err != nil {
+				printErr(err)
+				continue
+			}
+			sendEvent(c, &event{
+				id:   lastEvent,
+				typ:  wt,
+				data: b,
+			})
+		}
+	}
 	broadcastData := func(wd *waitData) {
 		for ws := range waiters {
-			go func(ws *websocket.Conn) {
-				if err := websocket.JSON.Send(ws, wd); err != nil {
-					srv.ch <- cmdDeleteWS(ws)
-				}
-			}(ws)
+			send(ws, wd)
 		}
+		notify(wd.Type)
+		record(wd)
 	}
 	broadcast := func(wt waitType) {
 		wd := srv.makeWaitData(wt)
@@ -50,39 +164,93 @@ func (srv *Server) commands(initialState State) {
 			Data: v,
 		})
 	}
+	// libraryVersion is the library version last sent to clients.
+	var libraryVersion uint64
+	// libraryChanged must be called after the library is modified. If its
+	// songs have changed, it re-evaluates smart playlists and sends the
+	// songs to clients: the changes to delta clients and all songs to
+	// others.
+	libraryChanged := func() {
+		if srv.library.Version() == libraryVersion {
+			return
+		}
+		if len(srv.SmartPlaylists) > 0 {
+			for name := range srv.SmartPlaylists {
+				srv.evalSmart(name)
+			}
+			broadcast(waitPlaylist)
+		}
+		var all, delta *waitData
+		for ws, w := range waiters {
+			if !w.delta {
+				if all == nil {
+					all = srv.makeWaitData(waitTracks)
+				}
+				send(ws, all)
+				continue
+			}
+			if delta == nil {
+				delta = &waitData{
+					Type: waitLibrary,
+					Data: srv.libraryDelta(libraryVersion),
+				}
+			}
+			send(ws, delta)
+		}
+		notify(waitLibrary)
+		if len(streams) == 0 {
+			dropEvent()
+		} else {
+			if delta == nil {
+				delta = &waitData{
+					Type: waitLibrary,
+					Data: srv.libraryDelta(libraryVersion),
+				}
+			}
+			record(delta)
+		}
+		libraryVersion = srv.library.Version()
+	}
 	newWS := func(c cmdNewWS) {
 		ws := (*websocket.Conn)(c.ws)
-		waiters[ws] = c.done
+		waiters[ws] = &waiter{
+			done:  c.done,
+			delta: c.delta,
+		}
 		inits := []waitType{
 			waitPlaylist,
 			waitProtocols,
 			waitStatus,
 			waitTracks,
 		}
+		if c.delta {
+			// Delta clients sync with the tracks API, starting from the
+			// version they last saw.
+			inits[len(inits)-1] = waitLibrary
+		}
 		for _, wt := range inits {
-			data := srv.makeWaitData(wt)
-			go func() {
-				if err := websocket.JSON.Send(ws, data); err != nil {
-					srv.ch <- cmdDeleteWS(ws)
-					return
-				}
-			}()
+			send(ws, srv.makeWaitData(wt))
 		}
 	}
 	deleteWS := func(c cmdDeleteWS) {
 		ws := (*websocket.Conn)(c)
-		ch := waiters[ws]
-		if ch == nil {
+		w := waiters[ws]
+		if w == nil {
 			return
 		}
-		close(ch)
+		close(w.done)
 		delete(waiters, ws)
 	}
 	prev = func() {
 		log.Println("prev")
-		srv.PlaylistIndex--
+		delta := -1
 		if srv.elapsed < time.Second*3 {
-			srv.PlaylistIndex--
+			delta--
+		}
+		if srv.Random {
+			srv.PlaylistIndex = srv.shuffleStep(srv.PlaylistIndex, delta)
+		} else {
+			srv.PlaylistIndex += delta
 		}
 		if srv.PlaylistIndex < 0 {
 			srv.PlaylistIndex = 0
@@ -109,18 +277,91 @@ func (srv *Server) commands(initialState State) {
 		play()
 	}
 	var forceNext = false
+	// playStart and listened describe the play of the current song. ended
+	// is set if it played to its end, skipping if the next or previous
+	// song was requested, and restarting if it is being restarted and its
+	// play continues.
+	var playStart time.Time
+	var listened time.Duration
+	var ended, skipping, restarting bool
+	// statsChanged must be called after song statistics change.
+	statsChanged := func() {
+		changed := false
+		for name, s := range srv.SmartPlaylists {
+			if s.UsesStats() {
+				srv.evalSmart(name)
+				changed = true
+			}
+		}
+		if changed {
+			broadcast(waitPlaylist)
+		}
+	}
+	endPlay := func() {
+		skipped := skipping
+		skipping = false
+		if srv.song == nil || restarting {
+			return
+		}
+		p := &Play{
+			Song:      srv.songID,
+			Start:     playStart,
+			Listened:  listened,
+			Completed: ended,
+			// A song that failed to start was not skipped.
+			Skipped: skipped && !ended && listened > 0,
+		}
+		ended = false
+		if err := srv.recordPlay(p); err != nil {
+			broadcastErr(err)
+		}
+		if srv.scrobbling() && scrobble.Eligible(listened, srv.info.Time) {
+			if err := srv.scrobbler.Scrobble(listen(srv.info, playStart)); err != nil {
+				broadcastErr(err)
+			}
+		}
+		srv.setStats(p.Song)
+		statsChanged()
+	}
+	rate := func(c cmdRate) {
+		if !srv.hasSong(c.id) {
+			c.done <- fmt.Errorf("unknown song: %s", c.id)
+			return
+		}
+		r := srv.ratings[c.id]
+		if c.stars != nil {
+			r.Stars = *c.stars
+		}
+		if c.favorite != nil {
+			r.Favorite = *c.favorite
+		}
+		err := srv.setRating(c.id, r)
+		c.done <- err
+		if err != nil {
+			return
+		}
+		srv.setStats(c.id)
+		statsChanged()
+		broadcastData(&waitData{
+			Type: waitRating,
+			Data: struct {
+				ID SongID
+				Rating
+			}{c.id, r},
+		})
+	}
 	stop = func() {
 		log.Println("stop")
+		wasEnded := ended
+		endPlay()
 		srv.state = stateStop
 		srv.audioch <- audioStop{}
 		if srv.song != nil || forceNext {
-			if srv.Random && len(srv.Queue) > 1 {
-				n := srv.PlaylistIndex
-				for n == srv.PlaylistIndex {
-					n = rand.Intn(len(srv.Queue))
-				}
-				srv.PlaylistIndex = n
-			} else {
+			switch {
+			case srv.RepeatOne && wasEnded:
+			case srv.Random:
+				srv.PlaylistIndex = srv.shuffleStep(srv.PlaylistIndex, 1)
+			default:
 				srv.PlaylistIndex++
 			}
 		}
@@ -130,11 +371,82 @@ func (srv *Server) commands(initialState State) {
 	}
 	var inst protocol.Instance
 	var sid SongID
+	// sampleRate and channels are the stream properties reported by Init for
+	// the current song. They are used when the protocol doesn't know them,
+	// which is the case for streams.
+	var sampleRate, channels int
+	setInfo := func(info codec.SongInfo) bool {
+		if info.SampleRate == 0 {
+			info.SampleRate = sampleRate
+		}
+		if info.Channels == 0 {
+			info.Channels = channels
+		}
+		if srv.info.Equal(&info) {
+			return false
+		}
+		srv.info = info
+		return true
+	}
 	sendNext := func() {
 		go func() {
 			srv.ch <- cmdNext
 		}()
 	}
+	// fillQueue appends songs chosen by the auto-DJ if it is on and the
+	// last song of the queue, in shuffle order if Random is set, is next to
+	// play.
+	fillQueue := func() {
+		if !srv.AutoDJ {
+			return
+		}
+		oldLen := len(srv.Queue)
+		if srv.Random {
+			if srv.PlaylistIndex < oldLen && srv.shuffleStep(srv.PlaylistIndex, 1) < oldLen {
+				return
+			}
+		} else if srv.PlaylistIndex < oldLen-1 {
+			return
+		}
+		recent := srv.Queue
+		if len(recent) > djRecent {
+			recent = recent[len(recent)-djRecent:]
+		}
+		ids := make([]codec.ID, len(recent))
+		for i, id := range recent {
+			ids[i] = codec.ID(id)
+		}
+		picked := srv.library.Pick(srv.DJ, ids, djBatch)
+		if len(picked) == 0 {
+			return
+		}
+		q := make(Playlist, len(srv.Queue), len(srv.Queue)+len(picked))
+		copy(q, srv.Queue)
+		for _, id := range picked {
+			q = append(q, SongID(id))
+		}
+		srv.Queue = q
+		if srv.PlaylistIndex > len(srv.Queue) {
+			srv.PlaylistIndex = len(srv.Queue)
+		}
+		if srv.Random {
+			srv.extendShuffle()
+			// If the shuffle order ended, continue with its first added
+			// song.
+			if srv.PlaylistIndex >= oldLen {
+				srv.PlaylistIndex = srv.Shuffle[oldLen]
+			}
+		}
+		from := make([]int, len(srv.Queue))
+		for i := range from {
+			from[i] = i
+			if i >= oldLen {
+				from[i] = -1
+			}
+		}
+		srv.queueChanged(from)
+		broadcast(waitPlaylist)
+	}
 	nextOpen := time.After(0)
 	tick = func() {
 		const expected = 4096
@@ -146,6 +458,7 @@ func (srv *Server) commands(initialState State) {
 			<-nextOpen
 			nextOpen = time.After(time.Second / 2)
 			defer broadcast(waitStatus)
+			fillQueue()
 			if len(srv.Queue) == 0 {
 				log.Println("empty queue")
 				stop()
@@ -154,6 +467,12 @@ func (srv *Server) commands(initialState State) {
 			if srv.PlaylistIndex >= len(srv.Queue) {
 				if srv.Repeat {
 					srv.PlaylistIndex = 0
+					if srv.Random {
+						// Start the new order at a random song.
+						srv.PlaylistIndex = rand.Intn(len(srv.Queue))
+						srv.reshuffle()
+						srv.PlaylistIndex = srv.Shuffle[0]
+					}
 				} else {
 					log.Println("end of queue", srv.PlaylistIndex, len(srv.Queue))
 					stop()
@@ -163,13 +482,14 @@ func (srv *Server) commands(initialState State) {
 
 			srv.songID = srv.Queue[srv.PlaylistIndex]
 			sid = srv.songID
+			sampleRate, channels = 0, 0
 			if info, err := srv.getSong(sid); err != nil {
 				broadcastErr(err)
 				forceNext = true
 				sendNext()
 				return
 			} else {
-				srv.info = *info
+				setInfo(*info)
 			}
 			inst = srv.Protocols[sid.Protocol()][sid.Key()]
 			song, err := inst.GetSong(sid.ID())
@@ -202,6 +522,12 @@ func (srv *Server) commands(initialState State) {
 				return
 			}
 			srv.elapsed = 0
+			sampleRate, channels = sr, ch
+			setInfo(srv.info)
+			playStart, listened = time.Now().UTC(), 0
+			if srv.scrobbling() {
+				srv.scrobbler.NowPlaying(listen(srv.info, playStart))
+			}
 			log.Println("playing", srv.info.Title, sr, ch)
 			srv.state = statePlay
 		}
@@ -214,15 +540,16 @@ func (srv *Server) commands(initialState State) {
 		// Check for updated song info.
 		if info, err := inst.Info(sid.ID()); err != nil {
 			broadcastErr(err)
-		} else if srv.info != *info {
-			srv.info = *info
+		} else if setInfo(*info) {
 			broadcast(waitStatus)
 		}
 	}
 	restart := func() {
 		log.Println("attempting to restart song")
 		n := srv.PlaylistIndex
+		restarting = true
 		stop()
+		restarting = false
 		srv.PlaylistIndex = n
 		play()
 	}
@@ -245,7 +572,7 @@ func (srv *Server) commands(initialState State) {
 			broadcastErr(err)
 			return
 		}
-		album := info.Album
+		album, albumArtist := info.Album, info.AlbumArtist
 		p, err := srv.getInstance(t.Protocol(), t.Key())
 		if err != nil {
 			broadcastErr(err)
@@ -259,45 +586,135 @@ func (srv *Server) commands(initialState State) {
 		top := codec.NewID(t.Protocol(), t.Key())
 		var ids []codec.ID
 		for id, si := range list {
-			if si.Album == album {
-				ids = append(ids, id)
+			if si.Album != album {
+				continue
+			}
+			// Compilations share an album artist but not an artist, so only
+			// use it to split same-named albums when it is set.
+			if albumArtist != "" && si.AlbumArtist != albumArtist {
+				continue
 			}
+			ids = append(ids, id)
 		}
 		slice.Sort(ids, func(i, j int) bool {
 			a := list[ids[i]]
 			b := list[ids[j]]
-			return a.Track < b.Track
+			return a.Less(b)
 		})
 		plc := PlaylistChange{[]string{"clear"}}
 		for _, v := range ids {
 			plc = append(plc, []string{"add", string(top.Push(string(v)))})
 		}
-		n, _, err := srv.playlistChange(srv.Queue, plc)
+		n, from, _, _, err := srv.playlistChange(srv.Queue, plc, -1, srv.versions.queue)
 		if err != nil {
 			broadcastErr(err)
 			return
 		}
+		srv.remember(srv.snapshot("queue: play track"))
 		stop()
 		srv.Queue = n
+		srv.queueChanged(from)
 		srv.PlaylistIndex = 0
 		for i, s := range srv.Queue {
 			if s == t {
 				srv.PlaylistIndex = i
 			}
 		}
+		if srv.Random {
+			srv.remapShuffle(from)
+		}
 		play()
 		broadcast(waitPlaylist)
 	}
+	// watchers holds the stop channels of instances being watched for
+	// changes.
+	watchers := make(map[codec.ID]chan struct{})
+	watch := func(name, key string, inst protocol.Instance) {
+		w, ok := inst.(protocol.Watcher)
+		id := codec.NewID(name, key)
+		if !ok || watchers[id] != nil {
+			return
+		}
+		stop := make(chan struct{})
+		watchers[id] = stop
+		go func() {
+			err := w.Watch(stop, func(apply func()) {
+				srv.ch <- cmdProtocolUpdate{
+					protocol: name,
+					key:      key,
+					inst:     inst,
+					apply:    apply,
+				}
+			})
+			if err != nil {
+				srv.ch <- cmdError(err)
+			}
+		}()
+	}
+	unwatch := func(name, key string) {
+		id := codec.NewID(name, key)
+		if stop := watchers[id]; stop != nil {
+			close(stop)
+			delete(watchers, id)
+		}
+	}
+	// index updates the library index with the songs of an instance.
+	index := func(name, key string) {
+		inst, err := srv.getInstance(name, key)
+		if err != nil {
+			srv.library.RemoveInstance(name, key)
+			return
+		}
+		songs, err := inst.List()
+		if err != nil {
+			broadcastErr(err)
+			return
+		}
+		srv.library.SetInstance(name, key, songs)
+	}
+	indexAll := func() {
+		srv.library = library.New()
+		for id := range srv.stats {
+			srv.setStats(id)
+		}
+		for id := range srv.ratings {
+			srv.setStats(id)
+		}
+		for name, insts := range srv.Protocols {
+			for key := range insts {
+				index(name, key)
+			}
+		}
+	}
+	watchAll := func() {
+		for name, insts := range srv.Protocols {
+			for key, inst := range insts {
+				watch(name, key, inst)
+			}
+		}
+	}
 	removeDeleted := func() {
 		for n, p := range srv.Playlists {
-			p = srv.removeDeleted(p)
-			if len(p) == 0 {
+			rem, _ := srv.removeDeleted(p)
+			if len(rem) == len(p) {
+				continue
+			}
+			if len(rem) == 0 {
 				delete(srv.Playlists, n)
 			} else {
-				srv.Playlists[n] = p
+				srv.Playlists[n] = rem
+			}
+			srv.versions.changePlaylist(n)
+		}
+		q, from := srv.removeDeleted(srv.Queue)
+		if len(q) != len(srv.Queue) {
+			srv.Queue = q
+			srv.PlaylistIndex = keptBefore(from, srv.PlaylistIndex)
+			srv.queueChanged(from)
+			if srv.Random {
+				srv.remapShuffle(from)
 			}
 		}
-		srv.Queue = srv.removeDeleted(srv.Queue)
 		if info, _ := srv.getSong(srv.songID); info == nil {
 			playing := srv.state == statePlay
 			stop()
@@ -308,81 +725,330 @@ func (srv *Server) commands(initialState State) {
 		}
 		broadcast(waitPlaylist)
 	}
-	protocolRemove := func(c cmdProtocolRemove) {
-		prots, ok := srv.Protocols[c.protocol]
-		if !ok {
-			return
+	protocolRemove := func(c cmdProtocolRemove) error {
+		if _, err := srv.getInstance(c.protocol, c.key); err != nil {
+			return notFound(err)
 		}
-		delete(prots, c.key)
-		broadcast(waitTracks)
+		delete(srv.Protocols[c.protocol], c.key)
+		index(c.protocol, c.key)
+		unwatch(c.protocol, c.key)
+		libraryChanged()
+		broadcast(waitProtocols)
+		return nil
+	}
+	// cancels holds the cancel functions of running refreshes.
+	cancels := make(map[codec.ID]context.CancelFunc)
+	// startRefresh marks id as in progress and returns the context its
+	// refresh should use.
+	startRefresh := func(id codec.ID) context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.inprogress[id] = true
+		cancels[id] = cancel
 		broadcast(waitProtocols)
+		return ctx
 	}
 	removeInProgress := func(c cmdRemoveInProgress) {
-		delete(srv.inprogress, codec.ID(c))
+		id := codec.ID(c)
+		delete(srv.inprogress, id)
+		if name, key := id.Pop(); srv.hasInstance(name, string(key)) {
+			index(name, string(key))
+			libraryChanged()
+		}
+		if len(srv.inprogress) == 0 {
+			srv.pruneArt()
+		}
+		if cancel := cancels[id]; cancel != nil {
+			cancel()
+			delete(cancels, id)
+		}
+		if _, ok := srv.progress[id]; ok {
+			delete(srv.progress, id)
+			broadcast(waitProgress)
+		}
 		broadcast(waitProtocols)
 	}
-	protocolAdd := func(c cmdProtocolAdd) {
+	protocolProgress := func(c cmdProtocolProgress) {
+		// Ignore late reports from finished refreshes.
+		if !srv.inprogress[c.id] {
+			return
+		}
+		srv.progress[c.id] = c.progress
+		broadcast(waitProgress)
+	}
+	protocolErrors := func(c cmdProtocolErrors) {
+		inst, err := srv.getInstance(c.protocol, c.key)
+		if err != nil {
+			c.done <- err
+			return
+		}
+		*c.errs = []protocol.ScanError{}
+		if r, ok := inst.(protocol.ErrorReporter); ok {
+			*c.errs = append(*c.errs, r.ScanErrors()...)
+		}
+		c.done <- nil
+	}
+	protocolCancel := func(c cmdProtocolCancel) {
+		if cancel := cancels[codec.NewID(c.protocol, c.key)]; cancel != nil {
+			cancel()
+		}
+	}
+	protocolAdd := func(c cmdProtocolAdd) error {
 		name, key := c.Name, c.Instance.Key()
 		id := codec.NewID(name, key)
 		if srv.inprogress[id] {
-			broadcastErr(fmt.Errorf("already adding %s: %s", name, key))
-			return
+			return conflict(fmt.Errorf("already adding %s: %s", name, key))
 		}
 		if _, err := srv.getInstance(name, key); err == nil {
-			broadcastErr(fmt.Errorf("already have %s: %s", name, key))
-			return
+			return conflict(fmt.Errorf("already have %s: %s", name, key))
 		}
-		srv.inprogress[id] = true
-		broadcast(waitProtocols)
+		ctx := startRefresh(id)
 		go func() {
 			defer func() {
 				srv.ch <- cmdRemoveInProgress(id)
 			}()
-			songs, err := c.Instance.Refresh()
-			if err != nil {
+			if err := srv.refresh(ctx, id, c.Instance); err != nil {
 				srv.ch <- cmdError(err)
 				return
 			}
-			for k, v := range songs {
-				if v.Time > 0 && v.Time < srv.MinDuration {
-					delete(songs, k)
-				}
-			}
 			srv.ch <- cmdProtocolAddInstance(c)
 		}()
+		return nil
 	}
 	protocolAddInstance := func(c cmdProtocolAddInstance) {
 		srv.Protocols[c.Name][c.Instance.Key()] = c.Instance
-		broadcast(waitTracks)
+		index(c.Name, c.Instance.Key())
+		watch(c.Name, c.Instance.Key(), c.Instance)
+		libraryChanged()
 		broadcast(waitProtocols)
 	}
+	protocolUpdate := func(c cmdProtocolUpdate) {
+		// Ignore updates from instances that have since been removed or
+		// replaced.
+		if inst, err := srv.getInstance(c.protocol, c.key); err != nil || inst != c.inst {
+			return
+		}
+		c.apply()
+		index(c.protocol, c.key)
+		libraryChanged()
+		removeDeleted()
+	}
 	queueChange := func(c cmdQueueChange) {
-		n, clear, err := srv.playlistChange(srv.Queue, PlaylistChange(c))
+		n, from, idx, clear, err := srv.playlistChange(srv.Queue, c.plc, srv.PlaylistIndex, srv.versions.queue)
+		c.done <- err
 		if err != nil {
 			broadcastErr(err)
 			return
 		}
+		srv.remember(srv.snapshot(changeAction("queue", c.plc)))
 		srv.Queue = n
+		srv.PlaylistIndex = idx
+		srv.queueChanged(from)
 		if clear || len(n) == 0 {
 			stop()
 			srv.PlaylistIndex = 0
 		}
+		if srv.Random {
+			srv.remapShuffle(from)
+		}
 		broadcast(waitPlaylist)
 	}
 	playlistChange := func(c cmdPlaylistChange) {
+		if _, ok := srv.SmartPlaylists[c.name]; ok {
+			err := fmt.Errorf("cannot change smart playlist: %s", c.name)
+			c.done <- err
+			broadcastErr(err)
+			return
+		}
 		p := srv.Playlists[c.name]
-		n, _, err := srv.playlistChange(p, c.plc)
+		n, _, _, _, err := srv.playlistChange(p, c.plc, -1, srv.versions.playlist(c.name))
+		c.done <- err
 		if err != nil {
 			broadcastErr(err)
 			return
 		}
+		srv.remember(srv.snapshot(changeAction("playlist "+c.name, c.plc)))
 		if len(n) == 0 {
 			delete(srv.Playlists, c.name)
 		} else {
 			srv.Playlists[c.name] = n
 		}
+		srv.versions.changePlaylist(c.name)
+		broadcast(waitPlaylist)
+	}
+	undo := func(c cmdUndo) {
+		var err error
+		if c.redo {
+			err = srv.undo(&srv.history.Redo, &srv.history.Undo, "redo")
+		} else {
+			err = srv.undo(&srv.history.Undo, &srv.history.Redo, "undo")
+		}
+		c.done <- err
+		if err != nil {
+			return
+		}
+		// Deleted songs were removed from the restored queue, so the
+		// snapshot's index may be past its end, and the current song may
+		// be elsewhere or gone.
+		idx := srv.PlaylistIndex
+		if idx >= len(srv.Queue) {
+			idx = len(srv.Queue) - 1
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		if srv.song != nil && (idx >= len(srv.Queue) || srv.Queue[idx] != srv.songID) {
+			found := false
+			for i, id := range srv.Queue {
+				if id == srv.songID {
+					idx, found = i, true
+					break
+				}
+			}
+			if !found {
+				stop()
+			}
+		}
+		srv.PlaylistIndex = idx
+		if len(srv.Queue) == 0 {
+			stop()
+			srv.PlaylistIndex = 0
+		}
 		broadcast(waitPlaylist)
 	}
+	smartChange := func(c cmdSmartChange) {
+		if c.smart == nil {
+			if _, ok := srv.SmartPlaylists[c.name]; !ok {
+				c.done <- fmt.Errorf("unknown smart playlist: %s", c.name)
+				return
+			}
+			delete(srv.SmartPlaylists, c.name)
+			delete(srv.smart, c.name)
+		} else {
+			if _, ok := srv.Playlists[c.name]; ok {
+				c.done <- fmt.Errorf("playlist already exists: %s", c.name)
+				return
+			}
+			srv.SmartPlaylists[c.name] = *c.smart
+			srv.evalSmart(c.name)
+		}
+		c.done <- nil
+		broadcast(waitPlaylist)
+	}
+	playlistImport := func(c cmdPlaylistImport) {
+		if _, ok := srv.SmartPlaylists[c.name]; ok {
+			c.done <- fmt.Errorf("cannot change smart playlist: %s", c.name)
+			return
+		}
+		p, unmatched := srv.resolve(c.entries, "")
+		if len(p) == 0 {
+			c.done <- fmt.Errorf("none of the %d entries are in the library", len(c.entries))
+			return
+		}
+		srv.remember(srv.snapshot("playlist " + c.name + ": import"))
+		srv.Playlists[c.name] = p
+		srv.versions.changePlaylist(c.name)
+		*c.res = ImportResult{
+			Songs:     len(p),
+			Unmatched: unmatched,
+		}
+		c.done <- nil
+		broadcast(waitPlaylist)
+	}
+	playlistExport := func(c cmdPlaylistExport) {
+		p, err := srv.playlist(c.name)
+		if err != nil {
+			c.done <- notFound(err)
+			return
+		}
+		*c.entries = srv.entries(p)
+		c.done <- nil
+	}
+	// synced holds the playlists as last read from or written to the
+	// playlist directory.
+	synced := make(map[string]Playlist)
+	// playlistDirLoaded is set once the files of the playlist directory
+	// have been read, so they are not overwritten before then.
+	playlistDirLoaded := false
+	var playlistDirStop chan struct{}
+	setPlaylistDir := func(dir string) {
+		if playlistDirStop != nil {
+			close(playlistDirStop)
+			playlistDirStop = nil
+		}
+		srv.PlaylistDir = dir
+		synced = make(map[string]Playlist)
+		playlistDirLoaded = false
+		if dir == "" {
+			return
+		}
+		playlistDirStop = make(chan struct{})
+		go srv.watchPlaylistDir(dir, playlistDirStop)
+	}
+	playlistFile := func(c cmdPlaylistFile) {
+		if c.dir != srv.PlaylistDir {
+			return
+		}
+		if c.loaded {
+			playlistDirLoaded = true
+			return
+		}
+		if c.err != nil {
+			broadcastErr(fmt.Errorf("playlist %s: %v", c.name, c.err))
+			return
+		}
+		if _, ok := srv.SmartPlaylists[c.name]; ok {
+			return
+		}
+		cur, exists := srv.Playlists[c.name]
+		if c.removed {
+			if _, ok := synced[c.name]; !ok || !exists {
+				return
+			}
+			srv.remember(srv.snapshot("playlist " + c.name + ": remove file"))
+			delete(srv.Playlists, c.name)
+			delete(synced, c.name)
+		} else {
+			p, unmatched := srv.resolve(c.entries, c.dir)
+			if len(unmatched) > 0 {
+				log.Printf("playlist %s: %d songs not found", c.name, len(unmatched))
+			}
+			// Don't let a file whose songs can't be found yet delete the
+			// playlist or, by syncing, be overwritten.
+			if len(p) == 0 {
+				return
+			}
+			synced[c.name] = p
+			if exists && playlistEqual(cur, p) {
+				return
+			}
+			srv.remember(srv.snapshot("playlist " + c.name + ": read file"))
+			srv.Playlists[c.name] = p
+		}
+		srv.versions.changePlaylist(c.name)
+		broadcast(waitPlaylist)
+	}
+	setDJ := func(c cmdSetDJ) {
+		srv.DJ = library.DJ(c)
+	}
+	setScrobble := func(c cmdScrobble) {
+		var svc scrobble.Service
+		if c.config != nil {
+			var err error
+			if svc, err = c.config.NewService(); err != nil {
+				c.done <- err
+				return
+			}
+		}
+		if srv.scrobbler != nil {
+			srv.scrobbler.Close()
+			srv.scrobbler = nil
+		}
+		srv.Scrobble = c.config
+		if svc != nil {
+			srv.scrobbler = scrobble.New(srv.db, svc)
+		}
+		c.done <- nil
+	}
 	queueSave := func() {
 		if srv.savePending {
 			return
@@ -393,10 +1059,80 @@ func (srv *Server) commands(initialState State) {
 		})
 	}
 	doSave := func() {
+		if playlistDirLoaded {
+			if err := srv.syncPlaylistDir(synced); err != nil {
+				broadcastErr(err)
+			}
+		}
 		if err := srv.save(); err != nil {
 			broadcastErr(err)
 		}
 	}
+	// alarmChecked is when alarms were last checked. rampStart and rampEnd
+	// are when the volume ramp of the last alarm starts and ends.
+	alarmChecked := time.Now()
+	var rampStart, rampEnd time.Time
+	alarm := func(a Alarm) {
+		log.Println("alarm", a.Time, a.Playlist)
+		if a.Playlist != "" {
+			p, err := srv.playlist(a.Playlist)
+			if err != nil {
+				broadcastErr(err)
+				return
+			}
+			srv.remember(srv.snapshot("queue: alarm"))
+			stop()
+			srv.Queue = append(Playlist(nil), p...)
+			srv.PlaylistIndex = 0
+			srv.queueChanged(nil)
+			if srv.Random {
+				srv.reshuffle()
+			}
+			broadcast(waitPlaylist)
+		} else if srv.state == statePlay {
+			return
+		}
+		rampStart = time.Now()
+		rampEnd = rampStart.Add(a.Ramp)
+		if srv.state == statePause {
+			pause()
+		} else {
+			play()
+		}
+	}
+	// schedule pauses playback when the sleep timer is up, sets off alarms
+	// and sets the volume for fades and ramps. It is called every second.
+	schedule := func() {
+		now := time.Now()
+		changed := false
+		if s := &srv.Schedule; !s.Sleep.IsZero() && !now.Before(s.Sleep) {
+			log.Println("sleep")
+			s.Sleep, s.Fade = time.Time{}, 0
+			if srv.state == statePlay {
+				pause()
+			}
+			changed = true
+		}
+		for _, a := range srv.Schedule.Alarms {
+			if a.due(alarmChecked, now) {
+				alarm(a)
+				changed = true
+				break
+			}
+		}
+		alarmChecked = now
+		if v := srv.Schedule.fade(now) * ramp(now, rampStart, rampEnd); v != srv.volume {
+			srv.volume = v
+			srv.audioch <- audioVolume(v)
+		}
+		if changed {
+			queueSave()
+			broadcast(waitStatus)
+		}
+	}
+	setSchedule := func(c cmdSetSchedule) {
+		srv.Schedule = Schedule(c)
+	}
 	addOAuth := func(c cmdAddOAuth) {
 		go func() {
 			prot, err := protocol.ByName(c.name)
@@ -427,11 +1163,39 @@ func (srv *Server) commands(initialState State) {
 	setMinDuration := func(c cmdMinDuration) {
 		srv.MinDuration = time.Duration(c)
 	}
-	doSeek := func(c cmdSeek) {
+	setModes := func(c cmdSetModes) {
+		reshuffle := false
+		if c.Random != nil && *c.Random != srv.Random {
+			srv.Random = *c.Random
+			reshuffle = true
+		}
+		if c.AlbumShuffle != nil && *c.AlbumShuffle != srv.AlbumShuffle {
+			srv.AlbumShuffle = *c.AlbumShuffle
+			reshuffle = true
+		}
+		if reshuffle && srv.Random {
+			srv.reshuffle()
+		}
+		if c.Repeat != nil {
+			srv.Repeat = *c.Repeat
+		}
+		if c.RepeatOne != nil {
+			srv.RepeatOne = *c.RepeatOne
+		}
+		if c.AutoDJ != nil && *c.AutoDJ != srv.AutoDJ {
+			srv.AutoDJ = *c.AutoDJ
+			fillQueue()
+		}
+	}
+	doSeek := func(c cmdSeek) error {
+		if srv.song == nil {
+			return conflict(fmt.Errorf("no song playing"))
+		}
 		if time.Duration(c) > srv.info.Time {
-			return
+			return badRequest(fmt.Errorf("position past end of song: %v", time.Duration(c)))
 		}
 		srv.audioch <- c
+		return nil
 	}
 	setSources := func(c cmdSetSources) {
 		ps := protocol.Map()
@@ -457,7 +1221,14 @@ func (srv *Server) commands(initialState State) {
 			}
 			ps[s.Protocol][s.Name] = p
 		}
+		for id := range watchers {
+			name, key := id.Pop()
+			unwatch(name, string(key))
+		}
 		srv.Protocols = ps
+		indexAll()
+		libraryChanged()
+		watchAll()
 		go func() {
 			// protocolRefresh uses srv.Protocols, so
 			for i, s := range c {
@@ -486,43 +1257,89 @@ func (srv *Server) commands(initialState State) {
 			c.err <- err
 			return
 		}
-		srv.inprogress[id] = true
-		broadcast(waitProtocols)
+		ctx := startRefresh(id)
 		go func() {
 			defer func() {
 				srv.ch <- cmdRemoveInProgress(id)
 			}()
-			f := inst.Refresh
+			var err error
 			if c.list {
-				f = inst.List
+				var songs protocol.SongList
+				songs, err = inst.List()
+				srv.do(func() { srv.removeShort(songs) })
+			} else {
+				err = srv.refresh(ctx, id, inst)
 			}
-			songs, err := f()
 			if err != nil {
 				c.err <- err
 				return
 			}
-			for k, v := range songs {
-				if v.Time > 0 && v.Time < srv.MinDuration {
-					delete(songs, k)
-				}
-			}
 			if c.doDelete {
 				srv.ch <- cmdRemoveDeleted{}
 			}
 			c.err <- nil
 		}()
 	}
+	search := func(c cmdSearch) {
+		ids := srv.library.Search(c.query)
+		r := SearchResult{
+			Total:  len(ids),
+			Offset: c.offset,
+			Songs:  []SongID{},
+		}
+		if c.offset < len(ids) {
+			ids = ids[c.offset:]
+			if len(ids) > c.limit {
+				ids = ids[:c.limit]
+			}
+			for _, id := range ids {
+				r.Songs = append(r.Songs, SongID(id))
+				if c.info {
+					r.Items = append(r.Items, srv.listItem(SongID(id), srv.library.Get(id).Info))
+				}
+			}
+		}
+		c.done <- r
+	}
+	tracks := func(c cmdTracks) {
+		ids := srv.library.IDs()
+		p := TrackPage{
+			Version: srv.library.Version(),
+			Total:   len(ids),
+			Offset:  c.offset,
+			Tracks:  []listItem{},
+		}
+		if c.offset < len(ids) {
+			ids = ids[c.offset:]
+			if len(ids) > c.limit {
+				ids = ids[:c.limit]
+			}
+			for _, id := range ids {
+				p.Tracks = append(p.Tracks, srv.listItem(SongID(id), srv.library.Get(id).Info))
+			}
+		}
+		c.done <- p
+	}
 	getStatus := func(c cmdGetStatus) {
-		c.status <- Status{
-			State:    srv.state,
-			Song:     srv.songID,
-			SongInfo: srv.info,
-			Elapsed:  srv.elapsed,
-			Time:     srv.info.Time,
-			Random:   srv.Random,
-			Repeat:   srv.Repeat,
+		c.status <- srv.status()
+	}
+	indexAll()
+	libraryVersion = srv.library.Version()
+	setPlaylistDir(srv.PlaylistDir)
+	if srv.Scrobble != nil {
+		c := cmdScrobble{
+			config: srv.Scrobble,
+			done:   make(chan error, 1),
+		}
+		setScrobble(c)
+		if err := <-c.done; err != nil {
+			log.Println("scrobble:", err)
 		}
 	}
+	for name := range srv.SmartPlaylists {
+		srv.evalSmart(name)
+	}
+	watchAll()
 	switch initialState {
 	case statePlay:
 		play()
@@ -545,6 +1362,7 @@ func (srv *Server) commands(initialState State) {
 		select {
 		case <-timer:
 			infoTimer()
+			schedule()
 		case c := <-ch:
 			if c, ok := c.(cmdSetTime); ok {
 				d := c.duration
@@ -552,12 +1370,21 @@ func (srv *Server) commands(initialState State) {
 				if change < 0 {
 					change = -change
 				}
+				if !c.force && d > srv.elapsed {
+					listened += d - srv.elapsed
+				}
 				srv.elapsed = d
 				if c.force || change > time.Second {
 					broadcast(waitStatus)
 				}
 				continue
 			}
+			var wait chan error
+			if w, ok := c.(cmdWait); ok {
+				c, wait = w.cmd, w.done
+			}
+			// err is the error returned by the command, if any.
+			var err error
 			save := true
 			doBroadcast := false
 			log.Printf("%T\n", c)
@@ -570,15 +1397,30 @@ func (srv *Server) commands(initialState State) {
 				case cmdStop:
 					stop()
 				case cmdNext:
+					skipping = true
 					next()
 				case cmdPause:
 					pause()
 				case cmdPrev:
+					skipping = true
 					prev()
 				case cmdRandom:
 					srv.Random = !srv.Random
+					if srv.Random {
+						srv.reshuffle()
+					}
+				case cmdAlbumShuffle:
+					srv.AlbumShuffle = !srv.AlbumShuffle
+					if srv.Random {
+						srv.reshuffle()
+					}
+				case cmdRepeatOne:
+					srv.RepeatOne = !srv.RepeatOne
 				case cmdRepeat:
 					srv.Repeat = !srv.Repeat
+				case cmdAutoDJ:
+					srv.AutoDJ = !srv.AutoDJ
+					fillQueue()
 				case cmdRestartSong:
 					restart()
 				default:
@@ -586,20 +1428,94 @@ func (srv *Server) commands(initialState State) {
 				}
 			case cmdPlayIdx:
 				playIdx(c)
+			case cmdSetModes:
+				setModes(c)
 			case cmdPlayTrack:
 				playTrack(c)
 			case cmdProtocolRemove:
-				protocolRemove(c)
+				err = protocolRemove(c)
 			case cmdQueueChange:
 				queueChange(c)
 			case cmdPlaylistChange:
 				playlistChange(c)
+			case cmdSmartChange:
+				smartChange(c)
+			case cmdUndo:
+				undo(c)
+			case cmdRate:
+				save = false
+				rate(c)
+			case cmdScrobble:
+				setScrobble(c)
+			case cmdSetDJ:
+				setDJ(c)
+			case cmdSetSchedule:
+				setSchedule(c)
+			case cmdGetSchedule:
+				save = false
+				c <- srv.Schedule
+			case cmdSongEnded:
+				ended = true
+				if srv.Schedule.StopAfter > 0 {
+					srv.Schedule.StopAfter--
+					if srv.Schedule.StopAfter == 0 {
+						stop()
+						break
+					}
+				}
+				next()
+			case cmdSongInfos:
+				save = false
+				items := make([]historyItem, len(c.plays))
+				for i, p := range c.plays {
+					items[i].Play = p
+					items[i].Info, _ = srv.getSong(p.Song)
+				}
+				c.done <- items
+			case cmdGetStats:
+				save = false
+				if c.id != "" {
+					c.done <- []SongStats{{c.id, srv.songStats(c.id)}}
+					break
+				}
+				stats := make([]SongStats, 0, len(srv.stats))
+				for id := range srv.stats {
+					stats = append(stats, SongStats{id, srv.songStats(id)})
+				}
+				for id := range srv.ratings {
+					if _, ok := srv.stats[id]; !ok {
+						stats = append(stats, SongStats{id, srv.songStats(id)})
+					}
+				}
+				c.done <- stats
+			case cmdPlaylistImport:
+				playlistImport(c)
+			case cmdPlaylistExport:
+				save = false
+				playlistExport(c)
+			case cmdPlaylistFile:
+				playlistFile(c)
+			case cmdPlaylistDir:
+				setPlaylistDir(string(c))
+			case cmdGetHistory:
+				save = false
+				c <- srv.history.info()
 			case cmdNewWS:
 				save = false
 				newWS(c)
 			case cmdDeleteWS:
 				save = false
 				deleteWS(c)
+			case cmdListen:
+				save = false
+				listeners[c] = true
+			case cmdEvents:
+				save = false
+				newEvents(c)
+			case cmdDo:
+				save = false
+				c.f()
+				close(c.done)
 			case cmdDoSave:
 				save = false
 				doSave()
@@ -607,19 +1523,30 @@ func (srv *Server) commands(initialState State) {
 				addOAuth(c)
 			case cmdSeek:
 				save = false
-				doSeek(c)
+				err = doSeek(c)
 			case cmdMinDuration:
 				setMinDuration(c)
 			case cmdSetSources:
 				setSources(c)
 			case cmdProtocolAdd:
-				protocolAdd(c)
+				err = protocolAdd(c)
 			case cmdProtocolAddInstance:
 				protocolAddInstance(c)
+			case cmdProtocolUpdate:
+				protocolUpdate(c)
 			case cmdRemoveDeleted:
 				removeDeleted()
 			case cmdRemoveInProgress:
 				removeInProgress(c)
+			case cmdProtocolProgress:
+				save = false
+				protocolProgress(c)
+			case cmdProtocolCancel:
+				save = false
+				protocolCancel(c)
+			case cmdProtocolErrors:
+				save = false
+				protocolErrors(c)
 			case cmdError:
 				broadcastErr(error(c))
 				save = false
@@ -629,7 +1556,20 @@ func (srv *Server) commands(initialState State) {
 			case cmdProtocolRefresh:
 				protocolRefresh(c)
 			case cmdGetStatus:
+				save = false
 				getStatus(c)
+			case cmdSearch:
+				save = false
+				search(c)
+			case cmdBrowse:
+				save = false
+				c.done <- c.f(srv.library)
+			case cmdTracks:
+				save = false
+				tracks(c)
+			case cmdTrackChanges:
+				save = false
+				c.done <- srv.libraryDelta(c.since)
 			default:
 				panic(c)
 			}
@@ -639,6 +1579,12 @@ func (srv *Server) commands(initialState State) {
 			if save || doBroadcast {
 				broadcast(waitStatus)
 			}
+			if err != nil {
+				broadcastErr(err)
+			}
+			if wait != nil {
+				wait <- err
+			}
 		}
 	}
 }
@@ -653,12 +1599,58 @@ const (
 	cmdPrev
 	cmdRandom
 	cmdRepeat
+	cmdAutoDJ
+	cmdAlbumShuffle
+	cmdRepeatOne
 	cmdStop
 	cmdRestartSong
 )
 
 type cmdSeek time.Duration
 
+// cmdDo runs f from the command loop.
+type cmdDo struct {
+	f    func()
+	done chan struct{}
+}
+
+// do runs f from the command loop, where it may use the server's fields.
+func (srv *Server) do(f func()) {
+	c := cmdDo{
+		f:    f,
+		done: make(chan struct{}),
+	}
+	srv.ch <- c
+	<-c.done
+}
+
+// cmdWait runs cmd and sends the error it returned, if any, to done.
+type cmdWait struct {
+	cmd  interface{}
+	done chan error
+}
+
+// wait runs cmd from the command loop and returns its error.
+func (srv *Server) wait(cmd interface{}) error {
+	c := cmdWait{
+		cmd:  cmd,
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	return <-c.done
+}
+
+// Modes sets the playback modes. Nil fields are left as they are.
+type Modes struct {
+	Random       *bool `json:",omitempty"`
+	AlbumShuffle *bool `json:",omitempty"`
+	Repeat       *bool `json:",omitempty"`
+	RepeatOne    *bool `json:",omitempty"`
+	AutoDJ       *bool `json:",omitempty"`
+}
+
+type cmdSetModes Modes
+
 type cmdPlayIdx int
 
 type cmdRemoveDeleted struct{}
@@ -667,11 +1659,42 @@ type cmdProtocolRemove struct {
 	protocol, key string
 }
 
-type cmdQueueChange PlaylistChange
+type cmdQueueChange struct {
+	plc  PlaylistChange
+	done chan error
+}
 
 type cmdPlaylistChange struct {
 	plc  PlaylistChange
 	name string
+	done chan error
+}
+
+// cmdSmartChange sets the smart playlist name, or removes it if smart is
+// nil.
+type cmdSmartChange struct {
+	name  string
+	smart *library.Smart
+	done  chan error
+}
+
+type cmdPlaylistDir string
+
+const (
+	// djBatch is the number of songs the auto-DJ appends at once.
+	djBatch = 3
+	// djRecent is the number of songs at the end of the queue the auto-DJ
+	// avoids repeating and bases its choices on.
+	djRecent = 50
+)
+
+type cmdSetDJ library.DJ
+
+// cmdScrobble sets the scrobbling configuration, or disables scrobbling if
+// config is nil.
+type cmdScrobble struct {
+	config *scrobble.Config
+	done   chan error
 }
 
 type cmdDoSave struct{}
@@ -700,8 +1723,30 @@ type cmdProtocolAdd struct {
 
 type cmdProtocolAddInstance cmdProtocolAdd
 
+// cmdProtocolUpdate applies changes found by a protocol.Watcher.
+type cmdProtocolUpdate struct {
+	protocol, key string
+	inst          protocol.Instance
+	apply         func()
+}
+
 type cmdRemoveInProgress codec.ID
 
+type cmdProtocolProgress struct {
+	id       codec.ID
+	progress protocol.Progress
+}
+
+type cmdProtocolCancel struct {
+	protocol, key string
+}
+
+type cmdProtocolErrors struct {
+	protocol, key string
+	errs          *[]protocol.ScanError
+	done          chan error
+}
+
 type cmdWaitData struct {
 	wt   waitType
 	done chan<- *waitData
@@ -714,3 +1759,26 @@ type cmdProtocolRefresh struct {
 }
 
 type cmdPlayTrack SongID
+
+type cmdSearch struct {
+	query         library.Query
+	offset, limit int
+	info          bool
+	done          chan SearchResult
+}
+
+type cmdTracks struct {
+	offset, limit int
+	done          chan TrackPage
+}
+
+type cmdTrackChanges struct {
+	since uint64
+	done  chan *LibraryDelta
+}
+
+// cmdBrowse runs f with the library index from the command loop.
+type cmdBrowse struct {
+	f    func(*library.Index) error
+	done chan error
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// eventBacklog is the number of events kept to resume event streams.
+const eventBacklog = 256
+
+// eventPing is how often an idle event stream is sent a comment to keep
+// proxies from closing it.
+const eventPing = 30 * time.Second
+
+// eventEpoch prefixes event IDs so IDs from an earlier run of the server
+// are not mistaken for current ones.
+var eventEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// eventTypes are the types clients may ask for. Tracks are not sent: like
+// delta websocket clients, event streams are sent library changes.
+var eventTypes = map[waitType]bool{
+	waitStatus:    true,
+	waitPlaylist:  true,
+	waitProtocols: true,
+	waitError:     true,
+	waitProgress:  true,
+	waitLibrary:   true,
+	waitRating:    true,
+}
+
+type event struct {
+	id   uint64
+	typ  waitType
+	data []byte
+}
+
+type cmdEvents struct {
+	// types are the types sent, or all types if empty.
+	types map[waitType]bool
+	// last is the ID of the last event the client saw, if resume is set.
+	last   uint64
+	resume bool
+	events chan *event
+	done   <-chan struct{}
+}
+
+func (c cmdEvents) wants(wt waitType) bool {
+	return len(c.types) == 0 || c.types[wt]
+}
+
+// parseEventID returns the number of the event ID s. ok is false if s is
+// not an ID of this run of the server.
+func parseEventID(s string) (id uint64, ok bool) {
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 || s[:i] != eventEpoch {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s[i+1:], 10, 64)
+	return id, err == nil
+}
+
+// Events streams the data sent to websocket clients as server-sent events
+// named by their type. The types parameter is a comma-separated list of the
+// types to send. Clients reconnecting with the Last-Event-ID header (or the
+// last_event_id parameter) are sent the events they missed. Events are only
+// kept while a stream is connected. New clients, and clients that missed too
+// many events or events that were not kept, are first sent the current
+// playlist, protocols, status and library version, as websocket clients are.
+func (srv *Server) Events(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		serveError(w, fmt.Errorf("streaming not supported"))
+		return
+	}
+	c := cmdEvents{
+		events: make(chan *event, eventBacklog+16),
+		done:   r.Context().Done(),
+	}
+	if s := r.FormValue("types"); s != "" {
+		c.types = make(map[waitType]bool)
+		for _, t := range strings.Split(s, ",") {
+			wt := waitType(t)
+			if !eventTypes[wt] {
+				http.Error(w, fmt.Sprintf("unknown type: %s", t), http.StatusBadRequest)
+				return
+			}
+			c.types[wt] = true
+		}
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.FormValue("last_event_id")
+	}
+	c.last, c.resume = parseEventID(last)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	srv.ch <- c
+
+	ping := time.NewTicker(eventPing)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				// The client fell behind. It reconnects and resumes.
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %s.%d\nevent: %s\ndata: %s\n\n", eventEpoch, e.id, e.typ, e.data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+		f.Flush()
+	}
+}
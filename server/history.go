@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// maxHistory is the number of snapshots kept for undo and for redo.
+const maxHistory = 50
+
+const (
+	dbUndo    = "undo"
+	dbHistory = "history"
+)
+
+// A snapshot is the queue and playlists before an edit.
+type snapshot struct {
+	Time time.Time
+	// Action describes the edit made after the snapshot was taken.
+	Action        string
+	Queue         Playlist
+	PlaylistIndex int
+	// Shuffle is the shuffle order of Queue, so that undo doesn't play
+	// the songs played before the edit again.
+	Shuffle   []int
+	Playlists map[string]Playlist
+}
+
+// history holds snapshots to undo and redo edits, most recent last.
+type history struct {
+	Undo, Redo []*snapshot
+}
+
+func pushSnapshot(s []*snapshot, v *snapshot) []*snapshot {
+	s = append(s, v)
+	if len(s) > maxHistory {
+		s = append([]*snapshot(nil), s[len(s)-maxHistory:]...)
+	}
+	return s
+}
+
+// snapshot returns the current queue and playlists. Playlists are never
+// modified in place, so they are not copied.
+func (srv *Server) snapshot(action string) *snapshot {
+	s := &snapshot{
+		Time:          time.Now().UTC(),
+		Action:        action,
+		Queue:         srv.Queue,
+		PlaylistIndex: srv.PlaylistIndex,
+		Shuffle:       append([]int(nil), srv.Shuffle...),
+		Playlists:     make(map[string]Playlist, len(srv.Playlists)),
+	}
+	for name, p := range srv.Playlists {
+		s.Playlists[name] = p
+	}
+	return s
+}
+
+// remember records s, taken before an edit, so it can be undone.
+func (srv *Server) remember(s *snapshot) {
+	srv.history.Undo = pushSnapshot(srv.history.Undo, s)
+	srv.history.Redo = nil
+}
+
+// undo restores the most recent snapshot of from and records the current
+// state in to. what names the operation in errors.
+func (srv *Server) undo(from, to *[]*snapshot, what string) error {
+	if len(*from) == 0 {
+		return fmt.Errorf("nothing to %s", what)
+	}
+	s := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = pushSnapshot(*to, srv.snapshot(s.Action))
+	q, kept := srv.removeDeleted(s.Queue)
+	srv.Queue = q
+	srv.PlaylistIndex = keptBefore(kept, s.PlaylistIndex)
+	srv.Shuffle = s.Shuffle
+	if srv.Random {
+		srv.remapShuffle(kept)
+	}
+	srv.queueChanged(nil)
+	old := srv.Playlists
+	srv.Playlists = make(map[string]Playlist, len(s.Playlists))
+	for name, p := range s.Playlists {
+		if p, _ = srv.removeDeleted(p); len(p) > 0 {
+			srv.Playlists[name] = p
+		}
+		if !playlistEqual(old[name], p) {
+			srv.versions.changePlaylist(name)
+		}
+	}
+	for name := range old {
+		if _, ok := srv.Playlists[name]; !ok {
+			srv.versions.changePlaylist(name)
+		}
+	}
+	return nil
+}
+
+// changeAction describes plc applied to the playlist named target.
+func changeAction(target string, plc PlaylistChange) string {
+	var cmds []string
+	seen := make(map[string]bool)
+	for _, c := range plc {
+		if len(c) == 0 || c[0] == "version" || seen[c[0]] {
+			continue
+		}
+		seen[c[0]] = true
+		cmds = append(cmds, c[0])
+	}
+	return fmt.Sprintf("%s: %s", target, strings.Join(cmds, ", "))
+}
+
+// HistoryItem describes an edit that can be undone or redone.
+type HistoryItem struct {
+	Time   time.Time
+	Action string
+}
+
+// HistoryInfo lists the edits that can be undone and redone, most recent
+// first.
+type HistoryInfo struct {
+	Undo []HistoryItem
+	Redo []HistoryItem
+}
+
+func (h *history) info() HistoryInfo {
+	list := func(s []*snapshot) []HistoryItem {
+		items := make([]HistoryItem, len(s))
+		for i, v := range s {
+			items[len(s)-1-i] = HistoryItem{
+				Time:   v.Time,
+				Action: v.Action,
+			}
+		}
+		return items
+	}
+	return HistoryInfo{
+		Undo: list(h.Undo),
+		Redo: list(h.Redo),
+	}
+}
+
+func (srv *Server) saveHistory() error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&srv.history); err != nil {
+		return err
+	}
+	return srv.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(dbUndo))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(dbHistory), buf.Bytes())
+	})
+}
+
+func (srv *Server) restoreHistory() error {
+	return srv.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbUndo))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(dbHistory))
+		if data == nil {
+			return nil
+		}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&srv.history)
+	})
+}
+
+type cmdUndo struct {
+	// redo redoes instead of undoes.
+	redo bool
+	done chan error
+}
+
+type cmdGetHistory chan HistoryInfo
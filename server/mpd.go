@@ -0,0 +1,1200 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/playlist"
+)
+
+// mpdVersion is the MPD protocol version reported to clients. Clients use
+// the search syntax of this version, which has tag and value pairs instead
+// of filter expressions.
+const mpdVersion = "0.19.0"
+
+// MPD error codes.
+const (
+	ackArg     = 2
+	ackUnknown = 5
+	ackNoExist = 50
+	ackSystem  = 52
+)
+
+type mpdError struct {
+	code int
+	msg  string
+}
+
+func (e *mpdError) Error() string {
+	return e.msg
+}
+
+func mpdErrorf(code int, format string, args ...interface{}) error {
+	return &mpdError{code, fmt.Sprintf(format, args...)}
+}
+
+// mpdSubsystems are the idle subsystems changed by each type of data sent to
+// clients.
+var mpdSubsystems = map[waitType][]string{
+	waitStatus:    {"player", "options"},
+	waitPlaylist:  {"playlist", "stored_playlist"},
+	waitTracks:    {"database"},
+	waitLibrary:   {"database"},
+	waitProtocols: {"update"},
+	waitProgress:  {"update"},
+	waitRating:    {"sticker"},
+}
+
+// ServeMPD accepts connections on l and serves a subset of the MPD protocol
+// on them, so MPD clients can control playback and the queue. Songs are
+// named by their locations in playlists, and song IDs are queue positions.
+func (srv *Server) ServeMPD(l net.Listener) error {
+	log.Println("moggio: mpd listening on", l.Addr())
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.serveMPD(conn)
+	}
+}
+
+type cmdListen struct {
+	events chan<- waitType
+	done   <-chan struct{}
+}
+
+type mpdConn struct {
+	srv *Server
+	// out holds the responses not yet written to the connection.
+	out bytes.Buffer
+}
+
+func (srv *Server) serveMPD(conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			select {
+			case lines <- s.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+	events := make(chan waitType, 64)
+	srv.ch <- cmdListen{events: events, done: done}
+
+	c := &mpdConn{srv: srv}
+	// changed are the subsystems changed since they were last reported by
+	// idle. idle are the subsystems the current idle command waits for, or
+	// empty to wait for any.
+	changed := make(map[string]bool)
+	idling := false
+	idle := make(map[string]bool)
+	// list holds the commands of a command list.
+	var list [][]string
+	inList, listOK := false, false
+	idleReply := func() {
+		var names []string
+		for s := range changed {
+			if len(idle) == 0 || idle[s] {
+				names = append(names, s)
+				delete(changed, s)
+			}
+		}
+		if len(names) == 0 {
+			return
+		}
+		sort.Strings(names)
+		for _, s := range names {
+			c.kv("changed", s)
+		}
+		c.out.WriteString("OK\n")
+		idling = false
+	}
+	if _, err := fmt.Fprintf(conn, "OK MPD %s\n", mpdVersion); err != nil {
+		return
+	}
+	for {
+		select {
+		case wt := <-events:
+			for _, s := range mpdSubsystems[wt] {
+				changed[s] = true
+			}
+			if idling {
+				idleReply()
+			}
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			args, err := mpdArgs(line)
+			if err == nil && len(args) == 0 {
+				err = mpdErrorf(ackUnknown, "No command given")
+			}
+			if err != nil {
+				c.ack(0, "", err)
+				break
+			}
+			switch name := args[0]; {
+			case idling:
+				// Only noidle may be sent while idle.
+				if name != "noidle" {
+					return
+				}
+				idling = false
+				c.out.WriteString("OK\n")
+			case inList:
+				if name != "command_list_end" {
+					list = append(list, args)
+					continue
+				}
+				c.exec(list, listOK)
+				inList, list = false, nil
+			case name == "command_list_begin", name == "command_list_ok_begin":
+				inList, listOK = true, name == "command_list_ok_begin"
+				continue
+			case name == "idle":
+				idling = true
+				idle = make(map[string]bool)
+				for _, s := range args[1:] {
+					idle[s] = true
+				}
+				idleReply()
+			case name == "noidle":
+			case name == "close":
+				return
+			default:
+				c.exec([][]string{args}, false)
+			}
+		}
+		if c.out.Len() > 0 {
+			if _, err := conn.Write(c.out.Bytes()); err != nil {
+				return
+			}
+			c.out.Reset()
+		}
+	}
+}
+
+// mpdArgs splits a command line into its arguments, which are separated by
+// spaces or quoted.
+func mpdArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		if line[0] != '"' {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			args = append(args, line[:i])
+			line = line[i:]
+			continue
+		}
+		var b strings.Builder
+		i := 1
+		for ; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return nil, mpdErrorf(ackArg, "Missing closing '\"'")
+		}
+		args = append(args, b.String())
+		line = line[i+1:]
+	}
+}
+
+// exec runs the commands of a command list. If listOK is set, each
+// successful command is followed by list_OK.
+func (c *mpdConn) exec(list [][]string, listOK bool) {
+	for i, args := range list {
+		err := mpdErrorf(ackUnknown, "unknown command %q", args[0])
+		if f := mpdCommands[args[0]]; f != nil {
+			err = f(c, args[1:])
+		}
+		if err != nil {
+			c.ack(i, args[0], err)
+			return
+		}
+		if listOK {
+			c.out.WriteString("list_OK\n")
+		}
+	}
+	c.out.WriteString("OK\n")
+}
+
+func (c *mpdConn) ack(i int, name string, err error) {
+	code := ackSystem
+	if e, ok := err.(*mpdError); ok {
+		code = e.code
+	}
+	fmt.Fprintf(&c.out, "ACK [%d@%d] {%s} %s\n", code, i, name, strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+func (c *mpdConn) kv(key string, v interface{}) {
+	s := strings.ReplaceAll(fmt.Sprint(v), "\n", " ")
+	fmt.Fprintf(&c.out, "%s: %s\n", key, s)
+}
+
+// do runs f from the command loop, where it may use the server's fields.
+func (c *mpdConn) do(f func()) {
+	c.srv.do(f)
+}
+
+func (c *mpdConn) queueChange(plc PlaylistChange) error {
+	cmd := cmdQueueChange{
+		plc:  plc,
+		done: make(chan error, 1),
+	}
+	c.srv.ch <- cmd
+	return <-cmd.done
+}
+
+// lookup returns the song at location uri. It must be called from the
+// command loop.
+func (c *mpdConn) lookup(uri string) (SongID, error) {
+	id, ok := c.srv.resolveEntry(playlist.Entry{Location: uri}, "")
+	if !ok {
+		return "", mpdErrorf(ackNoExist, "No such song")
+	}
+	return id, nil
+}
+
+// mpdTag is a song tag known to MPD clients.
+type mpdTag struct {
+	name string
+	get  func(*codec.SongInfo) string
+}
+
+var mpdTags = []mpdTag{
+	{"Artist", func(si *codec.SongInfo) string { return si.Artist }},
+	{"AlbumArtist", func(si *codec.SongInfo) string { return si.AlbumArtist }},
+	{"Title", func(si *codec.SongInfo) string { return si.Title }},
+	{"Album", func(si *codec.SongInfo) string { return si.Album }},
+	{"Track", func(si *codec.SongInfo) string { return mpdNumber(si.Track) }},
+	{"Disc", func(si *codec.SongInfo) string { return mpdNumber(float64(si.Disc)) }},
+	{"Date", func(si *codec.SongInfo) string { return mpdNumber(float64(si.Year)) }},
+	{"Genre", func(si *codec.SongInfo) string { return si.Genre }},
+	{"Composer", func(si *codec.SongInfo) string { return si.Composer }},
+	{"Comment", func(si *codec.SongInfo) string { return si.Comment }},
+}
+
+func mpdNumber(f float64) string {
+	if f <= 0 {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func mpdBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (c *mpdConn) song(id SongID, info *codec.SongInfo) {
+	c.kv("file", location(id))
+	if info == nil {
+		return
+	}
+	for _, t := range mpdTags {
+		if v := t.get(info); v != "" {
+			c.kv(t.name, v)
+		}
+	}
+	if info.Time > 0 {
+		c.kv("Time", int(info.Time.Seconds()+0.5))
+		c.kv("duration", fmt.Sprintf("%.3f", info.Time.Seconds()))
+	}
+}
+
+// queued writes the queue songs in [start, end). It must be called from the
+// command loop.
+func (c *mpdConn) queued(start, end int) {
+	for i := start; i < end; i++ {
+		id := c.srv.Queue[i]
+		info, _ := c.srv.getSong(id)
+		c.song(id, info)
+		c.kv("Pos", i)
+		c.kv("Id", c.srv.mpdIDs.id(i, len(c.srv.Queue)))
+	}
+}
+
+// mpdIDs holds the MPD song IDs of the queue songs, which unlike their
+// positions stay the same while the queue is edited. It must only be used
+// from the command loop.
+type mpdIDs struct {
+	ids  []int
+	last int
+}
+
+// edit updates the IDs after an edit of the queue, which now has n songs.
+// from is as described by Server.queueChanged. Added songs get new IDs.
+func (m *mpdIDs) edit(from []int, n int) {
+	ids := make([]int, n)
+	for i := range ids {
+		if from != nil && from[i] >= 0 && from[i] < len(m.ids) {
+			ids[i] = m.ids[from[i]]
+		} else {
+			m.last++
+			ids[i] = m.last
+		}
+	}
+	m.ids = ids
+}
+
+// id returns the ID of the song at pos of a queue of n songs.
+func (m *mpdIDs) id(pos, n int) int {
+	// The queue restored at start has no IDs yet.
+	if len(m.ids) != n {
+		m.edit(nil, n)
+	}
+	return m.ids[pos]
+}
+
+// pos returns the position of the song with id in a queue of n songs, or
+// -1 if there is none.
+func (m *mpdIDs) pos(id, n int) int {
+	if len(m.ids) != n {
+		m.edit(nil, n)
+	}
+	for i, v := range m.ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// byID returns f with its first argument, the ID of a queue song, replaced
+// by the song's position.
+func byID(f func(*mpdConn, []string) error) func(*mpdConn, []string) error {
+	return func(c *mpdConn, args []string) error {
+		if len(args) == 0 {
+			return f(c, args)
+		}
+		id, err := mpdInt(args[0])
+		if err != nil {
+			return err
+		}
+		pos := -1
+		c.do(func() {
+			pos = c.srv.mpdIDs.pos(id, len(c.srv.Queue))
+		})
+		if pos < 0 {
+			return mpdErrorf(ackNoExist, "No such song")
+		}
+		return f(c, append([]string{strconv.Itoa(pos)}, args[1:]...))
+	}
+}
+
+func mpdInt(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, mpdErrorf(ackArg, "Integer expected: %s", s)
+	}
+	return i, nil
+}
+
+func mpdFlag(s string) (bool, error) {
+	switch s {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, mpdErrorf(ackArg, "Boolean (0/1) expected: %s", s)
+}
+
+func mpdSeconds(s string) (time.Duration, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, mpdErrorf(ackArg, "Float expected: %s", s)
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+// mpdRange parses a position or a START:END range of positions, where END
+// may be omitted, of a list of n songs.
+func mpdRange(s string, n int) (start, end int, err error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		if start, err = mpdInt(s); err != nil {
+			return 0, 0, err
+		}
+		end = start + 1
+	} else {
+		if start, err = mpdInt(s[:i]); err != nil {
+			return 0, 0, err
+		}
+		end = n
+		if s[i+1:] != "" {
+			if end, err = mpdInt(s[i+1:]); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	if start < 0 || start > end || end > n {
+		return 0, 0, mpdErrorf(ackArg, "Bad song index")
+	}
+	return start, end, nil
+}
+
+func mpdArgCount(args []string, min, max int) error {
+	if len(args) < min || len(args) > max {
+		return mpdErrorf(ackArg, "wrong number of arguments")
+	}
+	return nil
+}
+
+var mpdCommands map[string]func(*mpdConn, []string) error
+
+func init() {
+	mpdCommands = map[string]func(*mpdConn, []string) error{
+		"add":              (*mpdConn).add,
+		"addid":            (*mpdConn).addID,
+		"clear":            (*mpdConn).clear,
+		"commands":         (*mpdConn).commands,
+		"consume":          (*mpdConn).consume,
+		"currentsong":      (*mpdConn).currentSong,
+		"delete":           (*mpdConn).delete,
+		"deleteid":         byID((*mpdConn).delete),
+		"find":             (*mpdConn).find,
+		"findadd":          (*mpdConn).findAdd,
+		"list":             (*mpdConn).list,
+		"listplaylist":     (*mpdConn).listPlaylist,
+		"listplaylistinfo": (*mpdConn).listPlaylistInfo,
+		"listplaylists":    (*mpdConn).listPlaylists,
+		"load":             (*mpdConn).load,
+		"move":             (*mpdConn).move,
+		"moveid":           byID((*mpdConn).move),
+		"next":             (*mpdConn).next,
+		"notcommands":      (*mpdConn).ping,
+		"outputs":          (*mpdConn).outputs,
+		"password":         (*mpdConn).ping,
+		"pause":            (*mpdConn).pause,
+		"ping":             (*mpdConn).ping,
+		"play":             (*mpdConn).play,
+		"playid":           byID((*mpdConn).play),
+		"playlistid":       byID((*mpdConn).playlistInfo),
+		"playlistinfo":     (*mpdConn).playlistInfo,
+		"plchanges":        (*mpdConn).plChanges,
+		"plchangesposid":   (*mpdConn).plChangesPosID,
+		"previous":         (*mpdConn).previous,
+		"random":           (*mpdConn).random,
+		"repeat":           (*mpdConn).repeat,
+		"search":           (*mpdConn).search,
+		"searchadd":        (*mpdConn).searchAdd,
+		"seek":             (*mpdConn).seek,
+		"seekcur":          (*mpdConn).seekCur,
+		"seekid":           byID((*mpdConn).seek),
+		"setvol":           (*mpdConn).setVol,
+		"shuffle":          (*mpdConn).shuffle,
+		"single":           (*mpdConn).single,
+		"stats":            (*mpdConn).stats,
+		"status":           (*mpdConn).status,
+		"stop":             (*mpdConn).stop,
+		"tagtypes":         (*mpdConn).tagTypes,
+		"update":           (*mpdConn).update,
+		"rescan":           (*mpdConn).update,
+	}
+}
+
+func (c *mpdConn) ping(args []string) error {
+	return nil
+}
+
+func (c *mpdConn) commands(args []string) error {
+	names := []string{"close", "command_list_begin", "command_list_end", "command_list_ok_begin", "idle", "noidle"}
+	for name := range mpdCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.kv("command", name)
+	}
+	return nil
+}
+
+func (c *mpdConn) tagTypes(args []string) error {
+	for _, t := range mpdTags {
+		c.kv("tagtype", t.name)
+	}
+	return nil
+}
+
+func (c *mpdConn) outputs(args []string) error {
+	c.kv("outputid", 0)
+	c.kv("outputname", "moggio")
+	c.kv("outputenabled", 1)
+	return nil
+}
+
+func (c *mpdConn) status(args []string) error {
+	c.do(func() {
+		srv := c.srv
+		st := srv.status()
+		c.kv("volume", -1)
+		c.kv("repeat", mpdBool(st.Repeat))
+		c.kv("random", mpdBool(st.Random))
+		c.kv("single", mpdBool(st.RepeatOne))
+		c.kv("consume", 0)
+		c.kv("playlist", srv.versions.queue)
+		c.kv("playlistlength", len(srv.Queue))
+		c.kv("state", st.State)
+		if srv.PlaylistIndex < len(srv.Queue) {
+			c.kv("song", srv.PlaylistIndex)
+			c.kv("songid", srv.mpdIDs.id(srv.PlaylistIndex, len(srv.Queue)))
+		}
+		if st.State == stateStop {
+			return
+		}
+		c.kv("time", fmt.Sprintf("%d:%d", int(st.Elapsed.Seconds()), int(st.Time.Seconds())))
+		c.kv("elapsed", fmt.Sprintf("%.3f", st.Elapsed.Seconds()))
+		if st.Time > 0 {
+			c.kv("duration", fmt.Sprintf("%.3f", st.Time.Seconds()))
+		}
+		if si := st.SongInfo; si.SampleRate > 0 && si.Channels > 0 {
+			c.kv("audio", fmt.Sprintf("%d:f:%d", si.SampleRate, si.Channels))
+		}
+		if st.SongInfo.Bitrate > 0 {
+			c.kv("bitrate", st.SongInfo.Bitrate/1000)
+		}
+	})
+	return nil
+}
+
+func (c *mpdConn) stats(args []string) error {
+	c.do(func() {
+		x := c.srv.library
+		var playtime time.Duration
+		for _, id := range x.IDs() {
+			playtime += x.Get(id).Info.Time
+		}
+		c.kv("artists", len(x.Artists()))
+		c.kv("albums", len(x.Albums("")))
+		c.kv("songs", x.Len())
+		c.kv("db_playtime", int(playtime.Seconds()))
+	})
+	return nil
+}
+
+func (c *mpdConn) currentSong(args []string) error {
+	c.do(func() {
+		if i := c.srv.PlaylistIndex; i < len(c.srv.Queue) {
+			c.queued(i, i+1)
+		}
+	})
+	return nil
+}
+
+func (c *mpdConn) playlistInfo(args []string) error {
+	if err := mpdArgCount(args, 0, 1); err != nil {
+		return err
+	}
+	var err error
+	c.do(func() {
+		start, end := 0, len(c.srv.Queue)
+		if len(args) > 0 {
+			if start, end, err = mpdRange(args[0], end); err != nil {
+				return
+			}
+		}
+		c.queued(start, end)
+	})
+	return err
+}
+
+// plChanges writes the whole queue if it has changed since the version in
+// args, since the changed songs aren't known.
+func (c *mpdConn) plChanges(args []string) error {
+	return c.changes(args, func(start, end int) {
+		c.queued(start, end)
+	})
+}
+
+func (c *mpdConn) plChangesPosID(args []string) error {
+	return c.changes(args, func(start, end int) {
+		for i := start; i < end; i++ {
+			c.kv("cpos", i)
+			c.kv("Id", c.srv.mpdIDs.id(i, len(c.srv.Queue)))
+		}
+	})
+}
+
+func (c *mpdConn) changes(args []string, f func(start, end int)) error {
+	if err := mpdArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	v, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return mpdErrorf(ackArg, "Integer expected: %s", args[0])
+	}
+	c.do(func() {
+		if v == c.srv.versions.queue {
+			return
+		}
+		start, end := 0, len(c.srv.Queue)
+		if len(args) > 1 {
+			if start, end, err = mpdRange(args[1], end); err != nil {
+				return
+			}
+		}
+		f(start, end)
+	})
+	return err
+}
+
+func (c *mpdConn) play(args []string) error {
+	if err := mpdArgCount(args, 0, 1); err != nil {
+		return err
+	}
+	var state State
+	var n int
+	c.do(func() {
+		state, n = c.srv.state, len(c.srv.Queue)
+	})
+	if len(args) > 0 {
+		i, err := mpdInt(args[0])
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= n {
+			return mpdErrorf(ackArg, "Bad song index")
+		}
+		c.srv.ch <- cmdPlayIdx(i)
+		return nil
+	}
+	if state == statePause {
+		c.srv.ch <- cmdPause
+	} else {
+		c.srv.ch <- cmdPlay
+	}
+	return nil
+}
+
+func (c *mpdConn) pause(args []string) error {
+	if err := mpdArgCount(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		c.srv.ch <- cmdPause
+		return nil
+	}
+	pause, err := mpdFlag(args[0])
+	if err != nil {
+		return err
+	}
+	var state State
+	c.do(func() {
+		state = c.srv.state
+	})
+	if pause && state == statePlay || !pause && state == statePause {
+		c.srv.ch <- cmdPause
+	}
+	return nil
+}
+
+func (c *mpdConn) stop(args []string) error {
+	c.srv.ch <- cmdStop
+	return nil
+}
+
+func (c *mpdConn) next(args []string) error {
+	c.srv.ch <- cmdNext
+	return nil
+}
+
+func (c *mpdConn) previous(args []string) error {
+	c.srv.ch <- cmdPrev
+	return nil
+}
+
+// seek seeks in the current song. Other songs can't be seeked since they
+// aren't loaded.
+func (c *mpdConn) seek(args []string) error {
+	if err := mpdArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	i, err := mpdInt(args[0])
+	if err != nil {
+		return err
+	}
+	d, err := mpdSeconds(args[1])
+	if err != nil {
+		return err
+	}
+	var playing bool
+	c.do(func() {
+		playing = c.srv.song != nil && c.srv.PlaylistIndex == i
+	})
+	if !playing {
+		return mpdErrorf(ackArg, "can only seek the current song")
+	}
+	c.srv.ch <- cmdSeek(d)
+	return nil
+}
+
+func (c *mpdConn) seekCur(args []string) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	s := args[0]
+	relative := strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-")
+	d, err := mpdSeconds(s)
+	if err != nil {
+		return err
+	}
+	var playing bool
+	c.do(func() {
+		playing = c.srv.song != nil
+		if relative {
+			d += c.srv.elapsed
+		}
+	})
+	if !playing {
+		return mpdErrorf(ackArg, "not playing")
+	}
+	if d < 0 {
+		d = 0
+	}
+	c.srv.ch <- cmdSeek(d)
+	return nil
+}
+
+// toggle sends cmd if the option read by get is not as in args.
+func (c *mpdConn) toggle(args []string, get func() bool, cmd controlCmd) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	on, err := mpdFlag(args[0])
+	if err != nil {
+		return err
+	}
+	var cur bool
+	c.do(func() {
+		cur = get()
+	})
+	if on != cur {
+		c.srv.ch <- cmd
+	}
+	return nil
+}
+
+func (c *mpdConn) random(args []string) error {
+	return c.toggle(args, func() bool { return c.srv.Random }, cmdRandom)
+}
+
+func (c *mpdConn) repeat(args []string) error {
+	return c.toggle(args, func() bool { return c.srv.Repeat }, cmdRepeat)
+}
+
+func (c *mpdConn) single(args []string) error {
+	return c.toggle(args, func() bool { return c.srv.RepeatOne }, cmdRepeatOne)
+}
+
+func (c *mpdConn) consume(args []string) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	if on, err := mpdFlag(args[0]); err != nil {
+		return err
+	} else if on {
+		return mpdErrorf(ackSystem, "consume is not supported")
+	}
+	return nil
+}
+
+func (c *mpdConn) setVol(args []string) error {
+	return mpdErrorf(ackSystem, "volume is not supported")
+}
+
+func (c *mpdConn) add(args []string) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	var id SongID
+	var err error
+	c.do(func() {
+		id, err = c.lookup(args[0])
+	})
+	if err != nil {
+		return err
+	}
+	return c.queueChange(PlaylistChange{{"add", string(id)}})
+}
+
+func (c *mpdConn) addID(args []string) error {
+	if err := mpdArgCount(args, 1, 2); err != nil {
+		return err
+	}
+	var id SongID
+	var err error
+	pos := -1
+	if len(args) > 1 {
+		if pos, err = mpdInt(args[1]); err != nil {
+			return err
+		}
+	}
+	c.do(func() {
+		id, err = c.lookup(args[0])
+		if pos < 0 {
+			pos = len(c.srv.Queue)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.queueChange(PlaylistChange{{"insert", strconv.Itoa(pos), string(id)}}); err != nil {
+		return err
+	}
+	c.do(func() {
+		if pos < len(c.srv.Queue) {
+			c.kv("Id", c.srv.mpdIDs.id(pos, len(c.srv.Queue)))
+		}
+	})
+	return nil
+}
+
+func (c *mpdConn) delete(args []string) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	var start, end int
+	var err error
+	c.do(func() {
+		start, end, err = mpdRange(args[0], len(c.srv.Queue))
+	})
+	if err != nil {
+		return err
+	}
+	var plc PlaylistChange
+	for i := start; i < end; i++ {
+		plc = append(plc, []string{"rem", strconv.Itoa(i)})
+	}
+	return c.queueChange(plc)
+}
+
+// move moves the songs in the range of args[0] so the first is at position
+// args[1].
+func (c *mpdConn) move(args []string) error {
+	if err := mpdArgCount(args, 2, 2); err != nil {
+		return err
+	}
+	to, err := mpdInt(args[1])
+	if err != nil {
+		return err
+	}
+	var start, end int
+	c.do(func() {
+		n := len(c.srv.Queue)
+		if start, end, err = mpdRange(args[0], n); err == nil && (to < 0 || to+end-start > n) {
+			err = mpdErrorf(ackArg, "Bad song index")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	// Queue moves insert before an index of the queue without the moved
+	// song, so moving down is to one past the final position.
+	var plc PlaylistChange
+	for i := start; i < end; i++ {
+		if to < start {
+			plc = append(plc, []string{"move", strconv.Itoa(i), strconv.Itoa(to + i - start)})
+		} else {
+			plc = append(plc, []string{"move", strconv.Itoa(start), strconv.Itoa(to + end - start)})
+		}
+	}
+	return c.queueChange(plc)
+}
+
+func (c *mpdConn) clear(args []string) error {
+	return c.queueChange(PlaylistChange{{"clear"}})
+}
+
+func (c *mpdConn) shuffle(args []string) error {
+	if err := mpdArgCount(args, 0, 0); err != nil {
+		return err
+	}
+	return c.queueChange(PlaylistChange{{"shuffle"}})
+}
+
+func (c *mpdConn) listPlaylists(args []string) error {
+	c.do(func() {
+		var names []string
+		for name := range c.srv.Playlists {
+			names = append(names, name)
+		}
+		for name := range c.srv.smart {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.kv("playlist", name)
+		}
+	})
+	return nil
+}
+
+// storedPlaylist writes the songs of the playlist args[0], with their tags if
+// info is set.
+func (c *mpdConn) storedPlaylist(args []string, info bool) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	var err error
+	c.do(func() {
+		var p Playlist
+		if p, err = c.srv.playlist(args[0]); err != nil {
+			err = mpdErrorf(ackNoExist, "No such playlist")
+			return
+		}
+		for _, id := range p {
+			if info {
+				si, _ := c.srv.getSong(id)
+				c.song(id, si)
+			} else {
+				c.kv("file", location(id))
+			}
+		}
+	})
+	return err
+}
+
+func (c *mpdConn) listPlaylist(args []string) error {
+	return c.storedPlaylist(args, false)
+}
+
+func (c *mpdConn) listPlaylistInfo(args []string) error {
+	return c.storedPlaylist(args, true)
+}
+
+func (c *mpdConn) load(args []string) error {
+	if err := mpdArgCount(args, 1, 1); err != nil {
+		return err
+	}
+	var err error
+	c.do(func() {
+		if _, err = c.srv.playlist(args[0]); err != nil {
+			err = mpdErrorf(ackNoExist, "No such playlist")
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return c.queueChange(PlaylistChange{{"add-playlist", args[0]}})
+}
+
+// mpdFilter returns a function reporting whether songs match the tag and
+// value pairs of args. If exact is set, tags must equal their values;
+// otherwise they must contain them, ignoring case.
+func mpdFilter(args []string, exact bool) (func(SongID, *codec.SongInfo) bool, error) {
+	if len(args)%2 != 0 {
+		return nil, mpdErrorf(ackArg, "incorrect arguments")
+	}
+	type rule struct {
+		tags  []mpdTag
+		file  bool
+		value string
+	}
+	var rules []rule
+	for i := 0; i < len(args); i += 2 {
+		r := rule{value: args[i+1]}
+		if !exact {
+			r.value = strings.ToLower(r.value)
+		}
+		switch name := args[i]; {
+		case strings.EqualFold(name, "any"):
+			r.tags, r.file = mpdTags, true
+		case strings.EqualFold(name, "file"):
+			r.file = true
+		default:
+			for _, t := range mpdTags {
+				if strings.EqualFold(t.name, name) {
+					r.tags = append(r.tags, t)
+				}
+			}
+			if r.tags == nil {
+				return nil, mpdErrorf(ackArg, "unknown tag type: %s", name)
+			}
+		}
+		rules = append(rules, r)
+	}
+	match := func(s, value string) bool {
+		if exact {
+			return s == value
+		}
+		return strings.Contains(strings.ToLower(s), value)
+	}
+	return func(id SongID, si *codec.SongInfo) bool {
+	Rules:
+		for _, r := range rules {
+			if r.file && match(location(id), r.value) {
+				continue
+			}
+			for _, t := range r.tags {
+				if match(t.get(si), r.value) {
+					continue Rules
+				}
+			}
+			return false
+		}
+		return true
+	}, nil
+}
+
+// matching returns the library songs matching the filter in args. It must be
+// called from the command loop.
+func (c *mpdConn) matching(args []string, exact bool) ([]SongID, error) {
+	f, err := mpdFilter(args, exact)
+	if err != nil {
+		return nil, err
+	}
+	var ids []SongID
+	x := c.srv.library
+	for _, id := range x.IDs() {
+		if f(SongID(id), x.Get(id).Info) {
+			ids = append(ids, SongID(id))
+		}
+	}
+	return ids, nil
+}
+
+func (c *mpdConn) search(args []string) error {
+	return c.searchSongs(args, false, false)
+}
+
+func (c *mpdConn) find(args []string) error {
+	return c.searchSongs(args, true, false)
+}
+
+func (c *mpdConn) searchAdd(args []string) error {
+	return c.searchSongs(args, false, true)
+}
+
+func (c *mpdConn) findAdd(args []string) error {
+	return c.searchSongs(args, true, true)
+}
+
+// searchSongs writes the songs matching the filter in args, or with add,
+// adds them to the queue.
+func (c *mpdConn) searchSongs(args []string, exact, add bool) error {
+	var ids []SongID
+	var err error
+	c.do(func() {
+		if ids, err = c.matching(args, exact); err != nil || add {
+			return
+		}
+		for _, id := range ids {
+			c.song(id, c.srv.library.Get(codec.ID(id)).Info)
+		}
+	})
+	if err != nil || !add || len(ids) == 0 {
+		return err
+	}
+	plc := make(PlaylistChange, len(ids))
+	for i, id := range ids {
+		plc[i] = []string{"add", string(id)}
+	}
+	return c.queueChange(plc)
+}
+
+// list writes the distinct values of a tag of the songs matching a filter.
+// The only grouping clients get is by the tag itself.
+func (c *mpdConn) list(args []string) error {
+	if len(args) == 0 {
+		return mpdErrorf(ackArg, "too few arguments for \"list\"")
+	}
+	var tag *mpdTag
+	for i, t := range mpdTags {
+		if strings.EqualFold(t.name, args[0]) {
+			tag = &mpdTags[i]
+		}
+	}
+	file := strings.EqualFold(args[0], "file")
+	if tag == nil && !file {
+		return mpdErrorf(ackArg, "unknown tag type: %s", args[0])
+	}
+	var filter []string
+	for i := 1; i < len(args); i++ {
+		if args[i] == "group" {
+			i++
+			continue
+		}
+		filter = append(filter, args[i])
+	}
+	// Old clients list the albums of an artist by giving only the artist.
+	if len(filter) == 1 && tag != nil && tag.name == "Album" {
+		filter = []string{"Artist", filter[0]}
+	}
+	var err error
+	c.do(func() {
+		var ids []SongID
+		if ids, err = c.matching(filter, true); err != nil {
+			return
+		}
+		seen := make(map[string]bool)
+		var values []string
+		for _, id := range ids {
+			v := location(id)
+			if tag != nil {
+				v = tag.get(c.srv.library.Get(codec.ID(id)).Info)
+			}
+			if v != "" && !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+		sort.Strings(values)
+		key := "file"
+		if tag != nil {
+			key = tag.name
+		}
+		for _, v := range values {
+			c.kv(key, v)
+		}
+	})
+	return err
+}
+
+// update refreshes all protocol instances.
+func (c *mpdConn) update(args []string) error {
+	var refresh []cmdProtocolRefresh
+	c.do(func() {
+		for name, insts := range c.srv.Protocols {
+			for key := range insts {
+				refresh = append(refresh, cmdProtocolRefresh{
+					protocol: name,
+					key:      key,
+					err:      make(chan error, 1),
+				})
+			}
+		}
+	})
+	for _, r := range refresh {
+		c.srv.ch <- r
+	}
+	c.kv("updating_db", 1)
+	return nil
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMPDArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"status", []string{"status"}},
+		{"  play \t 3 ", []string{"play", "3"}},
+		{`add "a b/c d.mp3"`, []string{"add", "a b/c d.mp3"}},
+		{`find artist "Guns \"N\" Roses"`, []string{"find", "artist", `Guns "N" Roses`}},
+		{`add "back\\slash"`, []string{"add", `back\slash`}},
+		{`list album "" x`, []string{"list", "album", "", "x"}},
+		{`a"b c`, []string{`a"b`, "c"}},
+	}
+	for _, test := range tests {
+		got, err := mpdArgs(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+		}
+	}
+	for _, line := range []string{`add "unterminated`, `add "escaped\"`} {
+		if _, err := mpdArgs(line); err == nil {
+			t.Errorf("%q: want error", line)
+		}
+	}
+}
+
+func TestMPDRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		ok         bool
+	}{
+		{"0", 0, 1, true},
+		{"4", 4, 5, true},
+		{"1:3", 1, 3, true},
+		{"2:", 2, 5, true},
+		{"5:", 5, 5, true},
+		{"0:5", 0, 5, true},
+		{"5", 0, 0, false},
+		{"-1", 0, 0, false},
+		{"3:2", 0, 0, false},
+		{"0:6", 0, 0, false},
+		{"a", 0, 0, false},
+		{"1:b", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, err := mpdRange(test.s, 5)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, want ok %v", test.s, err, test.ok)
+			continue
+		}
+		if start != test.start || end != test.end {
+			t.Errorf("%q: got [%d, %d), want [%d, %d)", test.s, start, end, test.start, test.end)
+		}
+	}
+}
+
+// mpdClient is an MPD connection to a server started for a test.
+type mpdClient struct {
+	t   *testing.T
+	srv *Server
+	r   *bufio.Reader
+	w   net.Conn
+}
+
+func newMPDClient(t *testing.T) *mpdClient {
+	srv, err := New(filepath.Join(t.TempDir(), "state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	go srv.serveMPD(server)
+	t.Cleanup(func() { client.Close() })
+	c := &mpdClient{t: t, srv: srv, r: bufio.NewReader(client), w: client}
+	if line := c.line(); !strings.HasPrefix(line, "OK MPD ") {
+		t.Fatalf("got greeting %q", line)
+	}
+	return c
+}
+
+func (c *mpdClient) line() string {
+	c.w.SetReadDeadline(time.Now().Add(5 * time.Second))
+	s, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimSuffix(s, "\n")
+}
+
+func (c *mpdClient) send(lines ...string) {
+	c.w.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	for _, l := range lines {
+		if _, err := c.w.Write([]byte(l + "\n")); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// response reads the lines up to and including OK or ACK.
+func (c *mpdClient) response() []string {
+	var lines []string
+	for {
+		l := c.line()
+		lines = append(lines, l)
+		if l == "OK" || strings.HasPrefix(l, "ACK ") {
+			return lines
+		}
+	}
+}
+
+func (c *mpdClient) setQueue(ids ...SongID) {
+	plc := PlaylistChange{{"clear"}}
+	for _, id := range ids {
+		plc = append(plc, []string{"add", string(id)})
+	}
+	m := &mpdConn{srv: c.srv}
+	if err := m.queueChange(plc); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *mpdClient) queue() Playlist {
+	var q Playlist
+	c.srv.do(func() {
+		q = append(q, c.srv.Queue...)
+	})
+	return q
+}
+
+// ids returns the IDs of the queue songs.
+func (c *mpdClient) ids() []string {
+	c.send("playlistinfo")
+	var ids []string
+	for _, l := range c.response() {
+		if strings.HasPrefix(l, "Id: ") {
+			ids = append(ids, strings.TrimPrefix(l, "Id: "))
+		}
+	}
+	return ids
+}
+
+func (c *mpdClient) queueString() string {
+	var s string
+	for _, id := range c.queue() {
+		s += string(id)
+	}
+	return s
+}
+
+func TestMPDMove(t *testing.T) {
+	c := newMPDClient(t)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"move 0 2", "bcade"},
+		{"move 2 0", "cabde"},
+		{"move 4 0", "eabcd"},
+		{"move 0 4", "bcdea"},
+		{"move 1:3 0", "bcade"},
+		{"move 0:2 3", "cdeab"},
+		{"move 3: 1", "adebc"},
+	}
+	for _, test := range tests {
+		c.setQueue("a", "b", "c", "d", "e")
+		c.send(test.cmd)
+		if res := c.response(); !reflect.DeepEqual(res, []string{"OK"}) {
+			t.Errorf("%s: got %q", test.cmd, res)
+			continue
+		}
+		if got := c.queueString(); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.cmd, got, test.want)
+		}
+	}
+	for _, cmd := range []string{"move 0 5", "move 3:5 4", "move 5 0", "move 0"} {
+		c.setQueue("a", "b", "c", "d", "e")
+		c.send(cmd)
+		if res := c.response(); !strings.HasPrefix(res[len(res)-1], "ACK [2@0] ") {
+			t.Errorf("%s: got %q, want an argument error", cmd, res)
+		}
+	}
+}
+
+func TestMPDID(t *testing.T) {
+	c := newMPDClient(t)
+	c.setQueue("a", "b", "c", "d", "e")
+	ids := c.ids()
+	if len(ids) != 5 {
+		t.Fatalf("got ids %q", ids)
+	}
+	// IDs stay valid when the songs before them are removed or moved.
+	for _, test := range []struct {
+		cmd   string
+		queue string
+	}{
+		{"delete 0", "bcde"},
+		{"moveid " + ids[2] + " 3", "bdec"},
+		{"move 0 3", "decb"},
+		{"deleteid " + ids[2], "deb"},
+	} {
+		c.send(test.cmd)
+		if res := c.response(); !reflect.DeepEqual(res, []string{"OK"}) {
+			t.Fatalf("%s: got %q", test.cmd, res)
+		}
+		if got := c.queueString(); got != test.queue {
+			t.Fatalf("%s: got queue %s, want %s", test.cmd, got, test.queue)
+		}
+	}
+	if got, want := c.ids(), []string{ids[3], ids[4], ids[1]}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got ids %q, want %q", got, want)
+	}
+	c.send("playlistid " + ids[1])
+	if res := c.response(); len(res) < 3 || res[len(res)-3] != "Pos: 2" {
+		t.Errorf("playlistid: got %q, want Pos: 2", res)
+	}
+	// Added songs get new IDs.
+	c.setQueue("a")
+	if got := c.ids(); len(got) != 1 || got[0] == ids[0] {
+		t.Errorf("got ids %q after replacing the queue, want a new ID", got)
+	}
+	c.send("deleteid " + ids[2])
+	if res := c.response(); !strings.HasPrefix(res[len(res)-1], "ACK [50@0] ") {
+		t.Errorf("deleteid of a removed song: got %q", res)
+	}
+}
+
+func TestMPDCommandList(t *testing.T) {
+	c := newMPDClient(t)
+	c.send("command_list_ok_begin", "ping", "ping", "command_list_end")
+	if res, want := c.response(), []string{"list_OK", "list_OK", "OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("list ok: got %q, want %q", res, want)
+	}
+	c.send("command_list_begin", "ping", "ping", "command_list_end")
+	if res, want := c.response(), []string{"OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("list: got %q, want %q", res, want)
+	}
+	// Errors are numbered by their index in the list, and stop it.
+	c.send("command_list_ok_begin", "ping", "bogus", "clear", "command_list_end")
+	want := []string{"list_OK", `ACK [5@1] {bogus} unknown command "bogus"`}
+	if res := c.response(); !reflect.DeepEqual(res, want) {
+		t.Errorf("list error: got %q, want %q", res, want)
+	}
+	c.send(`play "x`)
+	if res, want := c.response(), []string{`ACK [2@0] {} Missing closing '"'`}; !reflect.DeepEqual(res, want) {
+		t.Errorf("bad quote: got %q, want %q", res, want)
+	}
+	c.send("delete")
+	if res, want := c.response(), []string{"ACK [2@0] {delete} wrong number of arguments"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("arguments: got %q, want %q", res, want)
+	}
+}
+
+func TestMPDIdle(t *testing.T) {
+	c := newMPDClient(t)
+	c.send("idle playlist")
+	c.setQueue("a")
+	if res, want := c.response(), []string{"changed: playlist", "OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("idle: got %q, want %q", res, want)
+	}
+	// noidle ends idle without changes.
+	c.send("idle database", "noidle")
+	if res, want := c.response(), []string{"OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("noidle: got %q, want %q", res, want)
+	}
+	// Changes are remembered until the next idle.
+	c.setQueue("b")
+	c.send("ping")
+	if res, want := c.response(), []string{"OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("ping: got %q, want %q", res, want)
+	}
+	c.send("idle playlist stored_playlist")
+	if res, want := c.response(), []string{"changed: playlist", "changed: stored_playlist", "OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("idle after change: got %q, want %q", res, want)
+	}
+	// noidle outside idle is ignored.
+	c.send("noidle", "ping")
+	if res, want := c.response(), []string{"OK"}; !reflect.DeepEqual(res, want) {
+		t.Errorf("noidle outside idle: got %q, want %q", res, want)
+	}
+}
@@ -0,0 +1,458 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	mprisName   = "org.mpris.MediaPlayer2.moggio"
+	mprisPath   = dbus.ObjectPath("/org/mpris/MediaPlayer2")
+	mprisRoot   = "org.mpris.MediaPlayer2"
+	mprisPlayer = "org.mpris.MediaPlayer2.Player"
+	mprisProps  = "org.freedesktop.DBus.Properties"
+	// mprisNoTrack is the track ID of no song.
+	mprisNoTrack = dbus.ObjectPath("/org/mpris/MediaPlayer2/TrackList/NoTrack")
+)
+
+// mpris implements the MPRIS interfaces. Its properties are made from the
+// server's Status.
+type mpris struct {
+	srv  *Server
+	conn *dbus.Conn
+	// base is the URL of the web interface, which serves cover art.
+	base string
+
+	mu sync.Mutex
+	// last are the properties last sent to the bus, and at the time elapsed
+	// was last.
+	last    map[string]map[string]dbus.Variant
+	song    SongID
+	elapsed time.Duration
+	at      time.Time
+}
+
+// ServeMPRIS exports the MPRIS interfaces on the D-Bus session bus, so
+// desktop media keys and widgets can control playback. base is the URL of the
+// web interface.
+func (srv *Server) ServeMPRIS(base string) error {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return err
+	}
+	if err := srv.serveMPRIS(conn, base); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+func (srv *Server) serveMPRIS(conn *dbus.Conn, base string) error {
+	m := &mpris{
+		srv:  srv,
+		conn: conn,
+		base: base,
+	}
+	if err := conn.Export((*mprisRootMethods)(m), mprisPath, mprisRoot); err != nil {
+		return err
+	}
+	if err := conn.ExportWithMap((*mprisPlayerMethods)(m), mprisMethods, mprisPath, mprisPlayer); err != nil {
+		return err
+	}
+	if err := conn.Export((*mprisPropMethods)(m), mprisPath, mprisProps); err != nil {
+		return err
+	}
+	if err := conn.Export(introspect.NewIntrospectable(m.node()), mprisPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+	reply, err := conn.RequestName(mprisName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("mpris: %s already taken", mprisName)
+	}
+	m.last = m.props(m.status())
+	events := make(chan waitType, 16)
+	srv.ch <- cmdListen{events: events}
+	go func() {
+		for wt := range events {
+			if wt == waitStatus {
+				m.update()
+			}
+		}
+	}()
+	log.Println("moggio: mpris on the session bus as", mprisName)
+	return nil
+}
+
+// status returns the Status.
+func (m *mpris) status() Status {
+	var st Status
+	m.srv.do(func() { st = m.srv.status() })
+	return st
+}
+
+// update emits PropertiesChanged for the properties changed since they were
+// last sent, and Seeked if the song's position jumped.
+func (m *mpris) update() {
+	st := m.status()
+	props := m.props(st)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, iface := range []string{mprisRoot, mprisPlayer} {
+		changed := make(map[string]dbus.Variant)
+		for name, v := range props[iface] {
+			if name == "Position" {
+				continue
+			}
+			if old, ok := m.last[iface][name]; !ok || !reflect.DeepEqual(old.Value(), v.Value()) {
+				changed[name] = v
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		if err := m.conn.Emit(mprisPath, mprisProps+".PropertiesChanged", iface, changed, []string{}); err != nil {
+			log.Println("mpris:", err)
+		}
+	}
+	// Position isn't sent as it changes, so clients extrapolate it and
+	// must be told about jumps.
+	expected := m.elapsed
+	if st.State == statePlay {
+		expected += time.Since(m.at)
+	}
+	if diff := st.Elapsed - expected; st.Song == m.song && (diff > time.Second || diff < -time.Second) {
+		if err := m.conn.Emit(mprisPath, mprisPlayer+".Seeked", st.Elapsed.Microseconds()); err != nil {
+			log.Println("mpris:", err)
+		}
+	}
+	m.last = props
+	m.song, m.elapsed, m.at = st.Song, st.Elapsed, time.Now()
+}
+
+// trackID returns the MPRIS track ID of id.
+func trackID(id SongID) dbus.ObjectPath {
+	if id == "" {
+		return mprisNoTrack
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return dbus.ObjectPath(fmt.Sprintf("/org/moggio/track/%x", h.Sum64()))
+}
+
+func (m *mpris) metadata(st Status) map[string]dbus.Variant {
+	md := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(trackID(st.Song)),
+	}
+	if st.Song == "" {
+		return md
+	}
+	si := st.SongInfo
+	add := func(key string, v interface{}) {
+		md[key] = dbus.MakeVariant(v)
+	}
+	if si.Time > 0 {
+		add("mpris:length", si.Time.Microseconds())
+	}
+	if si.ImageURL != "" {
+		u := si.ImageURL
+		if strings.HasPrefix(u, "/") {
+			u = m.base + u
+		}
+		add("mpris:artUrl", u)
+	}
+	title := si.Title
+	if si.SongTitle != "" {
+		title = si.SongTitle
+	}
+	if title != "" {
+		add("xesam:title", title)
+	}
+	for key, v := range map[string]string{
+		"xesam:artist":      si.Artist,
+		"xesam:albumArtist": si.AlbumArtist,
+		"xesam:genre":       si.Genre,
+		"xesam:composer":    si.Composer,
+		"xesam:comment":     si.Comment,
+	} {
+		if v != "" {
+			add(key, []string{v})
+		}
+	}
+	if si.Album != "" {
+		add("xesam:album", si.Album)
+	}
+	if si.Track > 0 {
+		add("xesam:trackNumber", int32(si.Track))
+	}
+	if si.Disc > 0 {
+		add("xesam:discNumber", int32(si.Disc))
+	}
+	if st.Song.Protocol() == "file" {
+		add("xesam:url", (&url.URL{Scheme: "file", Path: st.Song.ID().Top()}).String())
+	}
+	return md
+}
+
+// props returns the properties of each interface.
+func (m *mpris) props(st Status) map[string]map[string]dbus.Variant {
+	v := dbus.MakeVariant
+	playback := "Stopped"
+	switch st.State {
+	case statePlay:
+		playback = "Playing"
+	case statePause:
+		playback = "Paused"
+	}
+	loop := "None"
+	switch {
+	case st.RepeatOne:
+		loop = "Track"
+	case st.Repeat:
+		loop = "Playlist"
+	}
+	return map[string]map[string]dbus.Variant{
+		mprisRoot: {
+			"CanQuit":             v(false),
+			"CanRaise":            v(false),
+			"HasTrackList":        v(false),
+			"Identity":            v("moggio"),
+			"SupportedUriSchemes": v([]string{}),
+			"SupportedMimeTypes":  v([]string{}),
+		},
+		mprisPlayer: {
+			"PlaybackStatus": v(playback),
+			"LoopStatus":     v(loop),
+			"Rate":           v(1.0),
+			"MinimumRate":    v(1.0),
+			"MaximumRate":    v(1.0),
+			"Shuffle":        v(st.Random),
+			"Metadata":       v(m.metadata(st)),
+			"Volume":         v(st.Volume),
+			"Position":       v(st.Elapsed.Microseconds()),
+			"CanGoNext":      v(true),
+			"CanGoPrevious":  v(true),
+			"CanPlay":        v(true),
+			"CanPause":       v(true),
+			"CanSeek":        v(st.Song != ""),
+			"CanControl":     v(true),
+		},
+	}
+}
+
+// mprisMethods maps the names of player methods that can't have their D-Bus
+// names to those names.
+var mprisMethods = map[string]string{
+	"SeekBy": "Seek",
+}
+
+// mprisWritable are the player properties that can be set. Volume is not
+// one of them since it is set by scheduled fades and ramps.
+var mprisWritable = map[string]bool{
+	"LoopStatus": true,
+	"Rate":       true,
+	"Shuffle":    true,
+}
+
+func (m *mpris) node() *introspect.Node {
+	player := introspect.Interface{
+		Name:    mprisPlayer,
+		Methods: introspect.Methods((*mprisPlayerMethods)(m)),
+		Signals: []introspect.Signal{{
+			Name: "Seeked",
+			Args: []introspect.Arg{{Name: "Position", Type: "x"}},
+		}},
+	}
+	for i, method := range player.Methods {
+		if name, ok := mprisMethods[method.Name]; ok {
+			player.Methods[i].Name = name
+		}
+	}
+	root := introspect.Interface{
+		Name:    mprisRoot,
+		Methods: introspect.Methods((*mprisRootMethods)(m)),
+	}
+	props := m.props(Status{})
+	for _, iface := range []*introspect.Interface{&root, &player} {
+		for name, v := range props[iface.Name] {
+			access := "read"
+			if iface == &player && mprisWritable[name] {
+				access = "readwrite"
+			}
+			iface.Properties = append(iface.Properties, introspect.Property{
+				Name:   name,
+				Type:   v.Signature().String(),
+				Access: access,
+			})
+		}
+		sort.Slice(iface.Properties, func(i, j int) bool {
+			return iface.Properties[i].Name < iface.Properties[j].Name
+		})
+	}
+	return &introspect.Node{
+		Name: string(mprisPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			root,
+			player,
+		},
+	}
+}
+
+type mprisRootMethods mpris
+
+func (m *mprisRootMethods) Raise() *dbus.Error {
+	return nil
+}
+
+func (m *mprisRootMethods) Quit() *dbus.Error {
+	return nil
+}
+
+type mprisPlayerMethods mpris
+
+func (m *mprisPlayerMethods) send(c interface{}) *dbus.Error {
+	m.srv.ch <- c
+	return nil
+}
+
+func (m *mprisPlayerMethods) Next() *dbus.Error {
+	return m.send(cmdNext)
+}
+
+func (m *mprisPlayerMethods) Previous() *dbus.Error {
+	return m.send(cmdPrev)
+}
+
+func (m *mprisPlayerMethods) PlayPause() *dbus.Error {
+	return m.send(cmdPause)
+}
+
+func (m *mprisPlayerMethods) Stop() *dbus.Error {
+	return m.send(cmdStop)
+}
+
+func (m *mprisPlayerMethods) Pause() *dbus.Error {
+	if (*mpris)(m).status().State == statePlay {
+		return m.send(cmdPause)
+	}
+	return nil
+}
+
+func (m *mprisPlayerMethods) Play() *dbus.Error {
+	if (*mpris)(m).status().State == statePause {
+		return m.send(cmdPause)
+	}
+	return m.send(cmdPlay)
+}
+
+// SeekBy seeks by offset microseconds. Seeking past the end of the song
+// plays the next one.
+func (m *mprisPlayerMethods) SeekBy(offset int64) *dbus.Error {
+	st := (*mpris)(m).status()
+	if st.Song == "" {
+		return nil
+	}
+	pos := st.Elapsed + time.Duration(offset)*time.Microsecond
+	if pos < 0 {
+		pos = 0
+	}
+	if st.Time > 0 && pos > st.Time {
+		return m.send(cmdNext)
+	}
+	return m.send(cmdSeek(pos))
+}
+
+// SetPosition seeks to position microseconds if trackID is the current song.
+func (m *mprisPlayerMethods) SetPosition(track dbus.ObjectPath, position int64) *dbus.Error {
+	st := (*mpris)(m).status()
+	pos := time.Duration(position) * time.Microsecond
+	if st.Song == "" || track != trackID(st.Song) || pos < 0 || st.Time > 0 && pos > st.Time {
+		return nil
+	}
+	return m.send(cmdSeek(pos))
+}
+
+func (m *mprisPlayerMethods) OpenUri(uri string) *dbus.Error {
+	return dbus.MakeFailedError(fmt.Errorf("opening URIs is not supported"))
+}
+
+type mprisPropMethods mpris
+
+func (m *mprisPropMethods) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	props, ok := (*mpris)(m).props((*mpris)(m).status())[iface]
+	if !ok {
+		return nil, prop.ErrIfaceNotFound
+	}
+	return props, nil
+}
+
+func (m *mprisPropMethods) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	props, err := m.GetAll(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := props[name]
+	if !ok {
+		return dbus.Variant{}, prop.ErrPropNotFound
+	}
+	return v, nil
+}
+
+// Set sets the loop and shuffle properties. The rate can only be 1, so it
+// is left as it is.
+func (m *mprisPropMethods) Set(iface, name string, value dbus.Variant) *dbus.Error {
+	if iface != mprisPlayer || !mprisWritable[name] {
+		return prop.ErrReadOnly
+	}
+	st := (*mpris)(m).status()
+	switch name {
+	case "LoopStatus":
+		s, ok := value.Value().(string)
+		if !ok {
+			return prop.ErrInvalidArg
+		}
+		var repeat, one bool
+		switch s {
+		case "None":
+		case "Track":
+			one = true
+		case "Playlist":
+			repeat = true
+		default:
+			return prop.ErrInvalidArg
+		}
+		if st.Repeat != repeat {
+			m.srv.ch <- cmdRepeat
+		}
+		if st.RepeatOne != one {
+			m.srv.ch <- cmdRepeatOne
+		}
+	case "Shuffle":
+		b, ok := value.Value().(bool)
+		if !ok {
+			return prop.ErrInvalidArg
+		}
+		if st.Random != b {
+			m.srv.ch <- cmdRandom
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+// ServeMPRIS does nothing: MPRIS is only supported on Linux.
+func (srv *Server) ServeMPRIS(base string) error {
+	return nil
+}
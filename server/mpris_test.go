@@ -0,0 +1,173 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mjibson/moggio/codec"
+)
+
+// privateBus starts a D-Bus daemon for the test and returns its address.
+func privateBus(t *testing.T) string {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address=1")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+func dbusConnect(t *testing.T, addr string) *dbus.Conn {
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// mprisLoop runs a minimal command loop for srv. It runs cmdDo functions,
+// sends listeners to listen and other commands to cmds.
+func mprisLoop(srv *Server, listen chan<- cmdListen, cmds chan<- interface{}) {
+	for c := range srv.ch {
+		switch c := c.(type) {
+		case cmdDo:
+			c.f()
+			close(c.done)
+		case cmdListen:
+			listen <- c
+		default:
+			cmds <- c
+		}
+	}
+}
+
+func TestMPRIS(t *testing.T) {
+	addr := privateBus(t)
+	srv := &Server{
+		ch:      make(chan interface{}),
+		state:   statePause,
+		songID:  SongID(codec.NewID("file", "/music", "/music/song.mp3")),
+		info:    codec.SongInfo{Title: "Song", Time: time.Minute},
+		elapsed: 10 * time.Second,
+		volume:  1,
+	}
+	listen := make(chan cmdListen, 1)
+	cmds := make(chan interface{}, 16)
+	go mprisLoop(srv, listen, cmds)
+	if err := srv.serveMPRIS(dbusConnect(t, addr), "http://localhost"); err != nil {
+		t.Fatal(err)
+	}
+	events := (<-listen).events
+
+	client := dbusConnect(t, addr)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(mprisPath)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 16)
+	client.Signal(signals)
+	obj := client.Object(mprisName, mprisPath)
+	get := func(name string) interface{} {
+		v, err := obj.GetProperty(mprisPlayer + "." + name)
+		if err != nil {
+			t.Fatalf("get %s: %v", name, err)
+		}
+		return v.Value()
+	}
+	signal := func(name string) *dbus.Signal {
+		for {
+			select {
+			case s := <-signals:
+				if s.Name == name {
+					return s
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no %s signal", name)
+			}
+		}
+	}
+	command := func() interface{} {
+		select {
+		case c := <-cmds:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("no command")
+		}
+		return nil
+	}
+
+	if got := get("PlaybackStatus"); got != "Paused" {
+		t.Errorf("PlaybackStatus is %v, want Paused", got)
+	}
+	if got := get("Position"); got != int64(10e6) {
+		t.Errorf("Position is %v, want 10000000", got)
+	}
+	md := get("Metadata").(map[string]dbus.Variant)
+	if got := md["xesam:title"].Value(); got != "Song" {
+		t.Errorf("title is %v, want Song", got)
+	}
+	if got := md["xesam:url"].Value(); got != "file:///music/song.mp3" {
+		t.Errorf("url is %v", got)
+	}
+	if err := obj.SetProperty(mprisPlayer+".Volume", dbus.MakeVariant(0.5)); err == nil {
+		t.Error("Volume was set, want read-only")
+	}
+
+	// Setting Shuffle toggles Random.
+	if err := obj.SetProperty(mprisPlayer+".Shuffle", dbus.MakeVariant(true)); err != nil {
+		t.Fatal(err)
+	}
+	if c := command(); c != cmdRandom {
+		t.Errorf("got command %v, want cmdRandom", c)
+	}
+	srv.do(func() { srv.Random = true })
+	events <- waitStatus
+	s := signal(mprisProps + ".PropertiesChanged")
+	changed := s.Body[1].(map[string]dbus.Variant)
+	if len(changed) != 1 || changed["Shuffle"].Value() != true {
+		t.Errorf("got changed properties %v, want Shuffle", changed)
+	}
+
+	// Seek is relative to the position, and seeking past the end plays
+	// the next song.
+	if err := obj.Call(mprisPlayer+".Seek", 0, int64(5e6)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if c := command(); c != cmdSeek(15*time.Second) {
+		t.Errorf("got command %v, want seek to 15s", c)
+	}
+	if err := obj.Call(mprisPlayer+".Seek", 0, int64(time.Hour/time.Microsecond)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if c := command(); c != cmdNext {
+		t.Errorf("got command %v, want cmdNext", c)
+	}
+
+	// Jumps of the position are sent as Seeked.
+	srv.do(func() { srv.elapsed = 40 * time.Second })
+	events <- waitStatus
+	if s := signal(mprisPlayer + ".Seeked"); s.Body[0] != int64(40e6) {
+		t.Errorf("Seeked to %v, want 40000000", s.Body[0])
+	}
+}
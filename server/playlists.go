@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/playlist"
+)
+
+// songScheme prefixes the locations of exported songs that have no file
+// path, like "moggio:stream/http%3A%2F%2Fexample.com%2F/0".
+const songScheme = "moggio:"
+
+// location returns the location of id in playlists. Songs of the file
+// protocol have their paths as locations.
+func location(id SongID) string {
+	if id.Protocol() == "file" {
+		return id.ID().Top()
+	}
+	parts := strings.Split(string(id), codec.IdSep)
+	for i, s := range parts {
+		parts[i] = url.PathEscape(s)
+	}
+	return songScheme + strings.Join(parts, "/")
+}
+
+// entries converts p to playlist entries.
+func (srv *Server) entries(p Playlist) []playlist.Entry {
+	entries := make([]playlist.Entry, 0, len(p))
+	for _, id := range p {
+		e := playlist.Entry{Location: location(id)}
+		if info, _ := srv.getSong(id); info != nil {
+			e.Artist = info.Artist
+			e.Title = info.Title
+			e.Album = info.Album
+			e.Duration = info.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// resolve returns the songs of entries. Relative locations are relative to
+// dir, or if dir is empty, match the end of a path. Entries whose location
+// can't be found are matched by artist, title and duration. unmatched are
+// the locations or names of the remaining entries.
+func (srv *Server) resolve(entries []playlist.Entry, dir string) (p Playlist, unmatched []string) {
+	for _, e := range entries {
+		if id, ok := srv.resolveEntry(e, dir); ok {
+			p = append(p, id)
+			continue
+		}
+		name := e.Location
+		if name == "" {
+			name = e.Artist + " - " + e.Title
+		}
+		unmatched = append(unmatched, name)
+	}
+	return p, unmatched
+}
+
+func (srv *Server) resolveEntry(e playlist.Entry, dir string) (SongID, bool) {
+	loc := e.Location
+	if strings.HasPrefix(loc, songScheme) {
+		parts := strings.Split(strings.TrimPrefix(loc, songScheme), "/")
+		for i, s := range parts {
+			parts[i], _ = url.PathUnescape(s)
+		}
+		if id := SongID(codec.NewID(parts...)); srv.hasSong(id) {
+			return id, true
+		}
+	} else if loc != "" {
+		if dir != "" && !filepath.IsAbs(loc) {
+			loc = filepath.Join(dir, loc)
+		}
+		if id, ok := srv.library.Path(loc); ok {
+			return SongID(id), true
+		}
+		if dir != "" {
+			if id, ok := srv.library.Path(e.Location); ok {
+				return SongID(id), true
+			}
+		}
+	}
+	if id, ok := srv.library.Find(e.Artist, e.Title, e.Duration); ok {
+		return SongID(id), true
+	}
+	return "", false
+}
+
+// ImportResult describes an imported playlist.
+type ImportResult struct {
+	Songs int
+	// Unmatched are the entries that were not found in the library.
+	Unmatched []string
+}
+
+// PlaylistImport replaces the playlist with the playlist file in the body.
+// The format parameter is a format name or file name as accepted by
+// playlist.Format. The playlist is left unchanged if none of the entries are
+// in the library.
+func (srv *Server) PlaylistImport(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	format, err := playlist.Format(form.Get("format"))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := playlist.Decode(body, format)
+	if err != nil {
+		return nil, err
+	}
+	var res ImportResult
+	c := cmdPlaylistImport{
+		name:    ps.ByName("playlist"),
+		entries: entries,
+		res:     &res,
+		done:    make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PlaylistExport writes the static or smart playlist as a playlist file in
+// the format given by the format parameter, M3U8 by default.
+func (srv *Server) PlaylistExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	format := playlist.M3U8
+	if f := r.FormValue("format"); f != "" {
+		var err error
+		if format, err = playlist.Format(f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	name := ps.ByName("playlist")
+	var entries []playlist.Entry
+	c := cmdPlaylistExport{
+		name:    name,
+		entries: &entries,
+		done:    make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		if e, ok := err.(*apiError); ok && e.status == http.StatusNotFound {
+			http.NotFound(w, r)
+		} else {
+			serveError(w, err)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	if err := playlist.Encode(&buf, format, name, entries); err != nil {
+		serveError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", playlist.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	w.Write(buf.Bytes())
+}
+
+type cmdPlaylistImport struct {
+	name    string
+	entries []playlist.Entry
+	res     *ImportResult
+	done    chan error
+}
+
+// cmdPlaylistExport receives the entries of a playlist, or nil if it
+// doesn't exist.
+type cmdPlaylistExport struct {
+	name    string
+	entries *[]playlist.Entry
+	done    chan error
+}
+
+// playlistFileQuiet is how long a playlist directory must have no events
+// before changed files are read.
+const playlistFileQuiet = time.Second
+
+// playlistFileName returns the playlist name of a file in the playlist
+// directory, or false if it isn't a playlist file.
+func playlistFileName(path string) (string, bool) {
+	if _, err := playlist.Format(filepath.Ext(path)); err != nil || filepath.Ext(path) == "" {
+		return "", false
+	}
+	base := filepath.Base(path)
+	if strings.HasPrefix(base, ".") {
+		return "", false
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)), true
+}
+
+// readPlaylistFile reads the playlist file at path for the command loop.
+func readPlaylistFile(path string) cmdPlaylistFile {
+	name, _ := playlistFileName(path)
+	c := cmdPlaylistFile{
+		name: name,
+		dir:  filepath.Dir(path),
+	}
+	format, _ := playlist.Format(path)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		c.removed = true
+		return c
+	} else if err != nil {
+		c.err = err
+		return c
+	}
+	defer f.Close()
+	c.entries, c.err = playlist.Decode(f, format)
+	return c
+}
+
+// watchPlaylistDir sends the playlists of dir to the command loop, and then
+// the playlists that are changed or removed until stop is closed.
+func (srv *Server) watchPlaylistDir(dir string, stop <-chan struct{}) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		srv.ch <- cmdError(err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		srv.ch <- cmdError(err)
+		return
+	}
+	send := func(c cmdPlaylistFile) {
+		select {
+		case srv.ch <- c:
+		case <-stop:
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, path := range matches {
+		if _, ok := playlistFileName(path); ok {
+			send(readPlaylistFile(path))
+		}
+	}
+	send(cmdPlaylistFile{
+		dir:    dir,
+		loaded: true,
+	})
+	dirty := make(map[string]bool)
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case err := <-w.Errors:
+			log.Println("playlist watch:", err)
+		case ev := <-w.Events:
+			if _, ok := playlistFileName(ev.Name); !ok || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			dirty[ev.Name] = true
+			quiet = time.After(playlistFileQuiet)
+		case <-quiet:
+			for path := range dirty {
+				send(readPlaylistFile(path))
+			}
+			dirty = make(map[string]bool)
+		}
+	}
+}
+
+// cmdPlaylistFile is a playlist file of the playlist directory that was
+// read, changed or removed. If loaded is set, it instead reports that all
+// files present when the directory was first watched have been sent.
+type cmdPlaylistFile struct {
+	name    string
+	dir     string
+	entries []playlist.Entry
+	removed bool
+	loaded  bool
+	err     error
+}
+
+// syncPlaylistDir writes the static playlists that changed since they were
+// last synced to the playlist directory, and removes the files of deleted
+// playlists. Playlists whose names can't be file names are skipped.
+func (srv *Server) syncPlaylistDir(synced map[string]Playlist) error {
+	if srv.PlaylistDir == "" {
+		return nil
+	}
+	path := func(name string) string {
+		return filepath.Join(srv.PlaylistDir, name+"."+playlist.M3U8)
+	}
+	for name, p := range srv.Playlists {
+		if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if s, ok := synced[name]; ok && playlistEqual(s, p) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := playlist.Encode(&buf, playlist.M3U8, name, srv.entries(p)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path(name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		synced[name] = p
+	}
+	for name := range synced {
+		if _, ok := srv.Playlists[name]; ok {
+			continue
+		}
+		if err := os.Remove(path(name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(synced, name)
+	}
+	return nil
+}
+
+func playlistEqual(a, b Playlist) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
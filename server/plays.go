@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/library"
+)
+
+// dbPlays is the bucket of play events, keyed by their big-endian start
+// time in nanoseconds.
+const dbPlays = "plays"
+
+// A Play is a song that was played.
+type Play struct {
+	Song  SongID
+	Start time.Time
+	// Listened is how much of the song was played, not counting seeks.
+	Listened time.Duration
+	// Completed is set if the song played to its end.
+	Completed bool
+	// Skipped is set if the song was left for the next or previous song
+	// before its end. Songs that are stopped or replaced are neither
+	// completed nor skipped.
+	Skipped bool
+}
+
+// addPlay adds p to the statistics in stats.
+func addPlay(stats map[SongID]library.Stats, p *Play) {
+	s := stats[p.Song]
+	if p.Completed {
+		s.Plays++
+	} else if p.Skipped {
+		s.Skips++
+	}
+	if p.Listened > 0 && p.Start.After(s.LastPlayed) {
+		s.LastPlayed = p.Start
+	}
+	stats[p.Song] = s
+}
+
+// recordPlay stores p and adds it to srv.stats.
+func (srv *Server) recordPlay(p *Play) error {
+	addPlay(srv.stats, p)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(p); err != nil {
+		return err
+	}
+	return srv.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(dbPlays))
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(p.Start.UnixNano()))
+		// Keys must be unique, even for plays started at the same time.
+		for b.Get(key) != nil {
+			binary.BigEndian.PutUint64(key, binary.BigEndian.Uint64(key)+1)
+		}
+		return b.Put(key, buf.Bytes())
+	})
+}
+
+// restorePlays derives srv.stats from the stored plays.
+func (srv *Server) restorePlays() error {
+	return srv.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbPlays))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var p Play
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&p); err != nil {
+				return err
+			}
+			addPlay(srv.stats, &p)
+			return nil
+		})
+	})
+}
+
+// songStats returns the statistics and rating of id.
+func (srv *Server) songStats(id SongID) library.Stats {
+	s := srv.stats[id]
+	r := srv.ratings[id]
+	s.Rating = r.Stars
+	s.Favorite = r.Favorite
+	return s
+}
+
+// setStats sets the statistics of id in the library.
+func (srv *Server) setStats(id SongID) {
+	srv.library.SetStats(codec.ID(id), srv.songStats(id))
+}
+
+// defaultHistoryLimit is the number of plays returned by History if no limit
+// is given.
+const defaultHistoryLimit = 100
+
+type historyItem struct {
+	Play
+	Info *codec.SongInfo `json:",omitempty"`
+}
+
+// History returns the most recent plays first. Results are paginated with
+// the offset and limit parameters.
+func (srv *Server) History(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	offset, limit, err := pagination(form, defaultHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	var plays []Play
+	err = srv.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbPlays))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		i := 0
+		for k, v := c.Last(); k != nil && len(plays) < limit; k, v = c.Prev() {
+			if i++; i <= offset {
+				continue
+			}
+			var p Play
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&p); err != nil {
+				return err
+			}
+			plays = append(plays, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := cmdSongInfos{
+		plays: plays,
+		done:  make(chan []historyItem, 1),
+	}
+	srv.ch <- c
+	return <-c.done, nil
+}
+
+// cmdSongInfos looks up the song info of plays.
+type cmdSongInfos struct {
+	plays []Play
+	done  chan []historyItem
+}
+
+// SongStats is the statistics of a song.
+type SongStats struct {
+	ID    SongID
+	Stats library.Stats
+}
+
+// defaultStatsLimit is the number of songs returned by Stats if no limit is
+// given.
+const defaultStatsLimit = 100
+
+// Stats returns the statistics of the song given by the id parameter, or of
+// all songs that have been played, skipped or rated. Those are sorted by the order
+// parameter: plays (the default), skips or lastplayed, in descending order,
+// and paginated with the offset and limit parameters.
+func (srv *Server) Stats(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	offset, limit, err := pagination(form, defaultStatsLimit)
+	if err != nil {
+		return nil, err
+	}
+	order := form.Get("order")
+	var less func(a, b library.Stats) bool
+	switch order {
+	case "", "plays":
+		less = func(a, b library.Stats) bool { return a.Plays > b.Plays }
+	case "skips":
+		less = func(a, b library.Stats) bool { return a.Skips > b.Skips }
+	case "lastplayed":
+		less = func(a, b library.Stats) bool { return a.LastPlayed.After(b.LastPlayed) }
+	default:
+		return nil, fmt.Errorf("unknown order: %s", order)
+	}
+	c := cmdGetStats{
+		id:   SongID(form.Get("id")),
+		done: make(chan []SongStats, 1),
+	}
+	srv.ch <- c
+	stats := <-c.done
+	if c.id != "" {
+		return stats[0].Stats, nil
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if less(a.Stats, b.Stats) != less(b.Stats, a.Stats) {
+			return less(a.Stats, b.Stats)
+		}
+		return a.ID < b.ID
+	})
+	if offset > len(stats) {
+		offset = len(stats)
+	}
+	stats = stats[offset:]
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats, nil
+}
+
+// cmdGetStats receives the statistics of id, or of all songs with
+// statistics if id is empty.
+type cmdGetStats struct {
+	id   SongID
+	done chan []SongStats
+}
+
+// cmdSongEnded is sent by the audio loop when a song plays to its end.
+type cmdSongEnded struct{}
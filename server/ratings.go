@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// dbRatings is the bucket of ratings, keyed by SongID. Ratings are kept
+// apart from protocol song lists so they survive refreshes and re-adds.
+const dbRatings = "ratings"
+
+// A Rating is a user's opinion of a song.
+type Rating struct {
+	// Stars is from 0 to 5 in steps of 0.5, where 0 is unrated.
+	Stars    float64 `json:",omitempty"`
+	Favorite bool    `json:",omitempty"`
+}
+
+// parseStars parses a rating from 0 to 5 in steps of 0.5.
+func parseStars(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > 5 || math.Mod(f*2, 1) != 0 {
+		return 0, fmt.Errorf("bad rating: %s; must be 0 to 5 in steps of 0.5", s)
+	}
+	return f, nil
+}
+
+// setRating stores the rating of id.
+func (srv *Server) setRating(id SongID, r Rating) error {
+	if r == (Rating{}) {
+		delete(srv.ratings, id)
+	} else {
+		srv.ratings[id] = r
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+		return err
+	}
+	return srv.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(dbRatings))
+		if err != nil {
+			return err
+		}
+		if r == (Rating{}) {
+			return b.Delete([]byte(id))
+		}
+		return b.Put([]byte(id), buf.Bytes())
+	})
+}
+
+func (srv *Server) restoreRatings() error {
+	return srv.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbRatings))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var r Rating
+			if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&r); err != nil {
+				return err
+			}
+			srv.ratings[SongID(k)] = r
+			return nil
+		})
+	})
+}
+
+// cmdRate changes the rating of id. Unset fields are not changed.
+type cmdRate struct {
+	id       SongID
+	stars    *float64
+	favorite *bool
+	done     chan error
+}
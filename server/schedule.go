@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Schedule holds the scheduled stops and alarms.
+type Schedule struct {
+	// StopAfter, if not 0, stops playback after this many more songs play
+	// to their end. 1 stops after the current song.
+	StopAfter int `json:",omitempty"`
+	// Sleep, if not zero, is when playback is paused.
+	Sleep time.Time `json:",omitempty"`
+	// Fade is how long before Sleep the volume starts fading out.
+	Fade   time.Duration `json:",omitempty"`
+	Alarms []Alarm
+}
+
+// Alarm starts playing a playlist at a time of day.
+type Alarm struct {
+	// Time is the local time of day, as "15:04".
+	Time string
+	// Days are the days of the week the alarm goes off. If empty, it goes off
+	// every day.
+	Days []time.Weekday `json:",omitempty"`
+	// Playlist is the playlist that replaces the queue. If empty, the queue
+	// is played as it is.
+	Playlist string `json:",omitempty"`
+	// Ramp is how long the volume takes to rise to full.
+	Ramp     time.Duration `json:",omitempty"`
+	Disabled bool          `json:",omitempty"`
+}
+
+// Check verifies the fields of s.
+func (s Schedule) Check() error {
+	if s.StopAfter < 0 {
+		return fmt.Errorf("stop after must not be negative")
+	}
+	if s.Fade < 0 {
+		return fmt.Errorf("fade must not be negative")
+	}
+	for _, a := range s.Alarms {
+		if _, err := time.Parse("15:04", a.Time); err != nil {
+			return fmt.Errorf("alarm time %q: expected HH:MM", a.Time)
+		}
+		for _, d := range a.Days {
+			if d < time.Sunday || d > time.Saturday {
+				return fmt.Errorf("unknown day: %d", d)
+			}
+		}
+		if a.Ramp < 0 {
+			return fmt.Errorf("ramp must not be negative")
+		}
+	}
+	return nil
+}
+
+// fade returns the volume at now while fading out to Sleep.
+func (s Schedule) fade(now time.Time) float64 {
+	if s.Sleep.IsZero() || s.Fade <= 0 {
+		return 1
+	}
+	left := s.Sleep.Sub(now)
+	switch {
+	case left >= s.Fade:
+		return 1
+	case left <= 0:
+		return 0
+	}
+	return float64(left) / float64(s.Fade)
+}
+
+// next returns the first time after t that a goes off.
+func (a Alarm) next(t time.Time) time.Time {
+	tod, err := time.Parse("15:04", a.Time)
+	if err != nil {
+		return time.Time{}
+	}
+	t = t.Local()
+	for i := 0; i <= 7; i++ {
+		d := t.AddDate(0, 0, i)
+		n := time.Date(d.Year(), d.Month(), d.Day(), tod.Hour(), tod.Minute(), 0, 0, time.Local)
+		if n.After(t) && a.on(n.Weekday()) {
+			return n
+		}
+	}
+	return time.Time{}
+}
+
+func (a Alarm) on(day time.Weekday) bool {
+	if len(a.Days) == 0 {
+		return true
+	}
+	for _, d := range a.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// due reports whether a went off after since and by now.
+func (a Alarm) due(since, now time.Time) bool {
+	if a.Disabled {
+		return false
+	}
+	n := a.next(since)
+	return !n.IsZero() && !n.After(now)
+}
+
+// ramp returns the volume at now while rising from start to end.
+func ramp(now, start, end time.Time) float64 {
+	if !now.Before(end) {
+		return 1
+	}
+	if !now.After(start) {
+		return 0
+	}
+	return float64(now.Sub(start)) / float64(end.Sub(start))
+}
+
+type cmdSetSchedule Schedule
+
+type cmdGetSchedule chan Schedule
+
+// GetSchedule returns the Schedule.
+func (srv *Server) GetSchedule(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	c := make(cmdGetSchedule, 1)
+	srv.ch <- c
+	return <-c, nil
+}
+
+// SetSchedule replaces the Schedule with the one in the body.
+func (srv *Server) SetSchedule(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var s Schedule
+	if err := json.NewDecoder(body).Decode(&s); err != nil {
+		return nil, err
+	}
+	if err := s.Check(); err != nil {
+		return nil, err
+	}
+	srv.ch <- cmdSetSchedule(s)
+	return nil, nil
+}
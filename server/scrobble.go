@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/scrobble"
+)
+
+// scrobbling reports whether the current song can be scrobbled. Services
+// require an artist and title.
+func (srv *Server) scrobbling() bool {
+	return srv.scrobbler != nil && srv.info.Artist != "" && srv.info.Title != ""
+}
+
+func listen(info codec.SongInfo, start time.Time) scrobble.Listen {
+	return scrobble.Listen{
+		Artist:   info.Artist,
+		Title:    info.Title,
+		Album:    info.Album,
+		Duration: info.Time,
+		Start:    start,
+	}
+}
+
+// SetScrobble sets the scrobble.Config in the body, or disables scrobbling if
+// the body is null.
+func (srv *Server) SetScrobble(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var config *scrobble.Config
+	if err := json.NewDecoder(body).Decode(&config); err != nil {
+		return nil, err
+	}
+	c := cmdScrobble{
+		config: config,
+		done:   make(chan error, 1),
+	}
+	srv.ch <- c
+	return nil, <-c.done
+}
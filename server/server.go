@@ -4,6 +4,7 @@ package server
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	crand "crypto/rand"
 	"encoding/gob"
 	"encoding/json"
@@ -12,6 +13,7 @@ import (
 	"math"
 	"math/big"
 	"math/rand"
+	"net"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -19,8 +21,11 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/mjibson/moggio/art"
 	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/library"
 	"github.com/mjibson/moggio/protocol"
+	"github.com/mjibson/moggio/scrobble"
 	"github.com/pkg/browser"
 )
 
@@ -39,16 +44,32 @@ func printErr(e error) {
 	println(string(b))
 }
 
-func ListenAndServe(stateFile, addr string, devMode bool) error {
+// ListenAndServe starts a server with the state in stateFile and serves its
+// web interface on addr and, if mpdAddr is not empty, the MPD protocol on
+// mpdAddr. On Linux, it is also controllable with MPRIS if there is a D-Bus
+// session bus.
+func ListenAndServe(stateFile, addr, mpdAddr string, devMode bool) error {
 	server, err := New(stateFile)
 	if err != nil {
 		return err
 	}
-	if !devMode {
-		host := addr
-		if strings.HasPrefix(host, ":") {
-			host = "localhost" + host
+	if mpdAddr != "" {
+		l, err := net.Listen("tcp", mpdAddr)
+		if err != nil {
+			return err
 		}
+		go func() {
+			log.Println(server.ServeMPD(l))
+		}()
+	}
+	host := addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	if err := server.ServeMPRIS("http://" + host); err != nil {
+		log.Println("mpris:", err)
+	}
+	if !devMode {
 		err := browser.OpenURL("http://" + host + "/")
 		if err != nil {
 			log.Println(err)
@@ -123,36 +144,115 @@ func (s SongID) Triple() (protocol, key string, id codec.ID) {
 type Server struct {
 	Queue     Playlist
 	Playlists map[string]Playlist
+	// SmartPlaylists are playlists of the songs matching rules. Their songs
+	// are evaluated into smart.
+	SmartPlaylists map[string]library.Smart
 
 	Repeat      bool
 	Random      bool
 	Protocols   map[string]map[string]protocol.Instance
 	MinDuration time.Duration
 
+	// RepeatOne repeats the current song when it ends.
+	RepeatOne bool
+	// AlbumShuffle makes Random shuffle albums instead of songs.
+	AlbumShuffle bool
+	// Shuffle is the order of the queue indexes played with Random.
+	Shuffle []int
+
+	// AutoDJ appends songs chosen by DJ when the queue runs out.
+	AutoDJ bool
+	DJ     library.DJ
+
+	// Schedule holds the scheduled stops and alarms.
+	Schedule Schedule
+
+	// PlaylistDir, if set, is a directory whose playlist files are kept in
+	// sync with Playlists. Playlists are written to it as M3U8 files.
+	PlaylistDir string
+
+	// Scrobble configures the scrobbling service, or is nil to disable
+	// scrobbling.
+	Scrobble *scrobble.Config
+
+	// StateVersion is the stateVersion the state file was last saved with.
+	StateVersion int
+
 	// Current song data.
 	PlaylistIndex int
 	songID        SongID
 	song          codec.Song
 	info          codec.SongInfo
 	elapsed       time.Duration
+	// volume is the gain of the audio, lowered by fades and ramps.
+	volume float64
+
+	versions versions
+	mpdIDs   mpdIDs
+	history  history
 
 	inprogress  map[codec.ID]bool
+	library     *library.Index
+	smart       map[string]Playlist
+	stats       map[SongID]library.Stats
+	ratings     map[SongID]Rating
+	scrobbler   *scrobble.Scrobbler
+	progress    map[codec.ID]protocol.Progress
 	ch          chan interface{}
 	audioch     chan interface{}
 	state       State
 	db          *bolt.DB
+	art         *art.Cache
 	savePending bool
 }
 
-func (srv *Server) removeDeleted(p Playlist) Playlist {
+// playlist returns the static or smart playlist with name.
+func (srv *Server) playlist(name string) (Playlist, error) {
+	if p, ok := srv.Playlists[name]; ok {
+		return p, nil
+	}
+	if p, ok := srv.smart[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown playlist: %s", name)
+}
+
+// evalSmart evaluates the smart playlist name into srv.smart.
+func (srv *Server) evalSmart(name string) {
+	ids := srv.library.Smart(srv.SmartPlaylists[name])
+	p := make(Playlist, len(ids))
+	for i, id := range ids {
+		p[i] = SongID(id)
+	}
+	srv.smart[name] = p
+}
+
+// removeDeleted returns p without the songs no longer in the library, and
+// the index in p of each song kept.
+func (srv *Server) removeDeleted(p Playlist) (Playlist, []int) {
 	var r Playlist
-	for _, id := range p {
+	var from []int
+	for i, id := range p {
 		if !srv.hasSong(id) {
 			continue
 		}
 		r = append(r, id)
+		from = append(from, i)
 	}
-	return r
+	return r, from
+}
+
+// keptBefore returns the number of songs kept by removeDeleted before index
+// i, which is the index of the song at i after the removal or, if it was
+// removed, of the song after it.
+func keptBefore(from []int, i int) int {
+	n := 0
+	for _, f := range from {
+		if f < i {
+			n++
+		}
+	}
+	return n
 }
 
 type PlaylistInfo []listItem
@@ -161,10 +261,7 @@ func (srv *Server) playlistInfo(p Playlist) PlaylistInfo {
 	r := make(PlaylistInfo, len(p))
 	for idx, id := range p {
 		info, _ := srv.getSong(id)
-		r[idx] = listItem{
-			ID:   id,
-			Info: info,
-		}
+		r[idx] = srv.listItem(id, info)
 	}
 	return r
 }
@@ -173,28 +270,78 @@ var dir = filepath.Join("server")
 
 func New(stateFile string) (*Server, error) {
 	srv := Server{
-		ch:          make(chan interface{}),
-		audioch:     make(chan interface{}),
-		Protocols:   protocol.Map(),
-		Playlists:   make(map[string]Playlist),
-		MinDuration: time.Second * 30,
-		inprogress:  make(map[codec.ID]bool),
+		ch:             make(chan interface{}),
+		audioch:        make(chan interface{}),
+		Protocols:      protocol.Map(),
+		Playlists:      make(map[string]Playlist),
+		SmartPlaylists: make(map[string]library.Smart),
+		MinDuration:    time.Second * 30,
+		volume:         1,
+		inprogress:     make(map[codec.ID]bool),
+		library:        library.New(),
+		smart:          make(map[string]Playlist),
+		stats:          make(map[SongID]library.Stats),
+		ratings:        make(map[SongID]Rating),
+		progress:       make(map[codec.ID]protocol.Progress),
+		versions:       newVersions(),
 	}
 	db, err := bolt.Open(stateFile, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
 	srv.db = db
+	srv.art = art.New()
+	if err := srv.art.SetDB(db); err != nil {
+		return nil, err
+	}
+	art.Default = srv.art
+	protocol.SetDB(db)
 	initialState, err := srv.restore()
 	if err != nil {
 		log.Println(err)
 	}
 	log.Println("started from", stateFile)
+	migrate := srv.migrate()
 	go srv.commands(initialState)
 	go srv.audio()
+	go migrate()
 	return &srv, nil
 }
 
+// stateVersion is incremented whenever the cached song lists need to be
+// rebuilt, for example when fields are added to codec.SongInfo.
+const stateVersion = 3
+
+// migrate returns a function that refreshes all instances if the state file
+// predates stateVersion. It must be called before commands() starts, and the
+// returned function must be called after.
+func (srv *Server) migrate() func() {
+	if srv.StateVersion >= stateVersion {
+		return func() {}
+	}
+	log.Printf("migrating state from version %d to %d", srv.StateVersion, stateVersion)
+	srv.StateVersion = stateVersion
+	var refresh []cmdProtocolRefresh
+	for name, insts := range srv.Protocols {
+		for key := range insts {
+			refresh = append(refresh, cmdProtocolRefresh{
+				protocol: name,
+				key:      key,
+				err:      make(chan error, 1),
+			})
+		}
+	}
+	return func() {
+		for i, c := range refresh {
+			c.doDelete = i == len(refresh)-1
+			srv.ch <- c
+			if err := <-c.err; err != nil {
+				srv.ch <- cmdError(err)
+			}
+		}
+	}
+}
+
 const (
 	dbBucket = "bucket"
 	dbServer = "server"
@@ -225,6 +372,15 @@ func (srv *Server) restore() (State, error) {
 	if err := decode(dbServer, srv); err != nil {
 		return 0, err
 	}
+	if err := srv.restoreHistory(); err != nil {
+		log.Println("restore history:", err)
+	}
+	if err := srv.restorePlays(); err != nil {
+		log.Println("restore plays:", err)
+	}
+	if err := srv.restoreRatings(); err != nil {
+		log.Println("restore ratings:", err)
+	}
 	var initialState State
 	if err := decode(dbState, &initialState); err != nil {
 		initialState = stateStop
@@ -271,10 +427,53 @@ func (srv *Server) save() error {
 	if err != nil {
 		return err
 	}
+	if err := srv.saveHistory(); err != nil {
+		return err
+	}
 	log.Println("save to db complete")
 	return nil
 }
 
+// refresh refreshes inst, which is identified by id, and reports its
+// progress to the command loop if it is a protocol.Scanner. The songs are
+// stored in inst from the command loop.
+func (srv *Server) refresh(ctx context.Context, id codec.ID, inst protocol.Instance) error {
+	s, ok := inst.(protocol.Scanner)
+	if !ok {
+		songs, err := inst.Refresh()
+		srv.do(func() { srv.removeShort(songs) })
+		return err
+	}
+	songs, apply, err := s.Scan(ctx, func(p protocol.Progress) {
+		srv.ch <- cmdProtocolProgress{
+			id:       id,
+			progress: p,
+		}
+	})
+	if apply != nil {
+		srv.do(func() {
+			apply()
+			srv.removeShort(songs)
+		})
+	}
+	return err
+}
+
+// removeShort removes the songs shorter than MinDuration from songs. It
+// should only be called by the commands() function.
+func (srv *Server) removeShort(songs protocol.SongList) {
+	for k, v := range songs {
+		if v.Time > 0 && v.Time < srv.MinDuration {
+			delete(songs, k)
+		}
+	}
+}
+
+func (srv *Server) hasInstance(name, key string) bool {
+	_, err := srv.getInstance(name, key)
+	return err == nil
+}
+
 func (srv *Server) getInstance(name, key string) (protocol.Instance, error) {
 	prots, ok := srv.Protocols[name]
 	if !ok {
@@ -287,51 +486,228 @@ func (srv *Server) getInstance(name, key string) (protocol.Instance, error) {
 	return inst, nil
 }
 
+// PlaylistChange is a list of commands that edit a playlist. Each command
+// is a name followed by its arguments:
+//
+//	clear: remove all songs
+//	rem <idx>: remove the song at idx
+//	rem-id <id>: remove all songs with id
+//	add <id>: append a song
+//	add-playlist <name>: append the songs of a static or smart playlist
+//	insert <idx> <id>: insert a song before idx
+//	move <from> <to>: move the song at from to before to
+//	shuffle: shuffle the songs
+//	dedupe: remove all but the first of each song
+//	version <n>: fail unless the playlist version is n
+//
+// Indexes refer to the playlist as edited by the earlier commands, except
+// that removed songs keep their positions until all commands are applied.
+// That way several rem commands can use the original indexes.
 type PlaylistChange [][]string
 
+// errVersion is returned by playlistChange if the playlist version has
+// changed.
+var errVersion = fmt.Errorf("playlist changed by another client; reload and try again")
+
+// versions holds the versions of the queue and of each playlist, which
+// change whenever they do. Versions are taken from one counter that starts
+// at the current time in seconds, so that clients do not mistake versions
+// from before a restart for current ones. Seconds keep it within the 32 bits
+// MPD clients use for the queue version.
+type versions struct {
+	last  uint64
+	start uint64
+	queue uint64
+	// playlists holds the versions of the playlists changed since start.
+	playlists map[string]uint64
+}
+
+func newVersions() versions {
+	now := uint64(time.Now().Unix())
+	return versions{
+		last:      now,
+		start:     now,
+		queue:     now,
+		playlists: make(map[string]uint64),
+	}
+}
+
+// changeQueue records a change of the queue.
+func (v *versions) changeQueue() {
+	v.last++
+	v.queue = v.last
+}
+
+// queueChanged must be called after the queue is edited. from holds the index
+// before the edit of each song of the queue, or -1 for added songs. It is nil
+// if the queue was replaced.
+func (srv *Server) queueChanged(from []int) {
+	srv.versions.changeQueue()
+	srv.mpdIDs.edit(from, len(srv.Queue))
+}
+
+// changePlaylist records a change of the playlist name.
+func (v *versions) changePlaylist(name string) {
+	v.last++
+	v.playlists[name] = v.last
+}
+
+// playlist returns the version of the playlist name.
+func (v *versions) playlist(name string) uint64 {
+	if n, ok := v.playlists[name]; ok {
+		return n
+	}
+	return v.start
+}
+
+// playlistChange applies plc to p. from holds the index in p of each song of
+// pl, or -1 for added songs. cur is an index of p to follow, usually the
+// current song; idx is its index in pl. If the song at cur is removed, idx is
+// the index of the song after it. version is the current version of p,
+// checked by the version command.
 func (srv *Server) playlistChange(
-	p Playlist, plc PlaylistChange,
-) (pl Playlist, cleared bool, err error) {
-	m := make([]SongID, len(p))
-	copy(m, p)
+	p Playlist, plc PlaylistChange, cur int, version uint64,
+) (pl Playlist, from []int, idx int, cleared bool, err error) {
+	type entry struct {
+		id   SongID
+		cur  bool
+		from int
+	}
+	m := make([]entry, len(p))
+	for i, id := range p {
+		m[i] = entry{id: id, cur: i == cur, from: i}
+	}
+	index := func(s string, max int) (int, error) {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 || i > max {
+			return 0, fmt.Errorf("unknown index: %v", i)
+		}
+		return i, nil
+	}
 	for _, c := range plc {
+		if len(c) == 0 {
+			return nil, nil, 0, false, fmt.Errorf("empty command")
+		}
 		cmd := c[0]
-		var arg string
+		var arg, arg2 string
 		if len(c) > 1 {
 			arg = c[1]
 		}
+		if len(c) > 2 {
+			arg2 = c[2]
+		}
 		switch cmd {
 		case "clear":
 			cleared = true
 			for i := range m {
-				m[i] = ""
+				m[i].id = ""
 			}
 		case "rem":
-			i, err := strconv.Atoi(arg)
+			i, err := index(arg, len(m)-1)
 			if err != nil {
-				return nil, false, err
+				return nil, nil, 0, false, err
 			}
-			if len(m) <= i {
-				return nil, false, fmt.Errorf("unknown index: %v", i)
+			m[i].id = ""
+		case "rem-id":
+			for i := range m {
+				if m[i].id == SongID(arg) {
+					m[i].id = ""
+				}
 			}
-			m[i] = ""
 		case "add":
-			m = append(m, SongID(arg))
+			m = append(m, entry{id: SongID(arg), from: -1})
+		case "add-playlist":
+			p, err := srv.playlist(arg)
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			for _, id := range p {
+				m = append(m, entry{id: id, from: -1})
+			}
+		case "insert":
+			i, err := index(arg, len(m))
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			if arg2 == "" {
+				return nil, nil, 0, false, fmt.Errorf("insert: missing id")
+			}
+			m = append(m[:i], append([]entry{{id: SongID(arg2), from: -1}}, m[i:]...)...)
+		case "move":
+			from, err := index(arg, len(m)-1)
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			to, err := index(arg2, len(m))
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			e := m[from]
+			m = append(m[:from], m[from+1:]...)
+			if to > from {
+				to--
+			}
+			m = append(m[:to], append([]entry{e}, m[to:]...)...)
+		case "shuffle":
+			rand.Shuffle(len(m), func(i, j int) {
+				m[i], m[j] = m[j], m[i]
+			})
+		case "dedupe":
+			seen := make(map[SongID]bool)
+			for i := range m {
+				if seen[m[i].id] {
+					m[i].id = ""
+				}
+				seen[m[i].id] = true
+			}
+		case "version":
+			v, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return nil, nil, 0, false, err
+			}
+			if v != version {
+				return nil, nil, 0, false, errVersion
+			}
 		default:
-			return nil, false, fmt.Errorf("unknown command: %v", cmd)
+			return nil, nil, 0, false, fmt.Errorf("unknown command: %v", cmd)
 		}
 	}
-	for _, id := range m {
-		if id != "" {
-			pl = append(pl, id)
+	idx = -1
+	for _, e := range m {
+		if e.cur && idx < 0 {
+			idx = len(pl)
 		}
+		if e.id != "" {
+			pl = append(pl, e.id)
+			from = append(from, e.from)
+		}
+	}
+	if idx < 0 {
+		idx = cur
 	}
 	return
 }
 
 type listItem struct {
-	ID   SongID
-	Info *codec.SongInfo
+	ID    SongID
+	Info  *codec.SongInfo
+	Stats *library.Stats `json:",omitempty"`
+}
+
+// listItem returns the list item of id, including its statistics if it has
+// any.
+func (srv *Server) listItem(id SongID, info *codec.SongInfo) listItem {
+	li := listItem{
+		ID:   id,
+		Info: info,
+	}
+	if s := srv.songStats(id); s != (library.Stats{}) {
+		li.Stats = &s
+	}
+	return li
 }
 
 type Status struct {
@@ -343,9 +719,33 @@ type Status struct {
 	// Elapsed time of current song.
 	Elapsed time.Duration
 	// Duration of current song.
-	Time   time.Duration
-	Random bool
-	Repeat bool
+	Time         time.Duration
+	Random       bool
+	Repeat       bool
+	RepeatOne    bool
+	AlbumShuffle bool
+	AutoDJ       bool
+	Schedule     Schedule
+	// Volume is the gain of the audio, from 0 to 1.
+	Volume float64
+}
+
+// status should only be called by the commands() function.
+func (srv *Server) status() Status {
+	return Status{
+		State:        srv.state,
+		Song:         srv.songID,
+		SongInfo:     srv.info,
+		Elapsed:      srv.elapsed,
+		Time:         srv.info.Time,
+		Random:       srv.Random,
+		Repeat:       srv.Repeat,
+		AutoDJ:       srv.AutoDJ,
+		RepeatOne:    srv.RepeatOne,
+		AlbumShuffle: srv.AlbumShuffle,
+		Schedule:     srv.Schedule,
+		Volume:       srv.volume,
+	}
 }
 
 func (srv *Server) getSong(id SongID) (*codec.SongInfo, error) {
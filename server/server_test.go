@@ -0,0 +1,30 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPlaylistChangeVersion(t *testing.T) {
+	srv := &Server{versions: newVersions()}
+	change := func(name string) error {
+		v := srv.versions.playlist(name)
+		plc := PlaylistChange{{"version", strconv.FormatUint(v, 10)}, {"add", "a"}}
+		_, _, _, _, err := srv.playlistChange(nil, plc, -1, v)
+		return err
+	}
+	q := srv.versions.queue
+	a := srv.versions.playlist("a")
+	srv.versions.changeQueue()
+	srv.versions.changePlaylist("b")
+	if srv.versions.playlist("a") != a {
+		t.Error("changing the queue and playlist b changed the version of playlist a")
+	}
+	if err := change("a"); err != nil {
+		t.Errorf("change with the current version: %v", err)
+	}
+	plc := PlaylistChange{{"version", strconv.FormatUint(q, 10)}}
+	if _, _, _, _, err := srv.playlistChange(nil, plc, -1, srv.versions.queue); err != errVersion {
+		t.Errorf("change with an old version: got %v, want errVersion", err)
+	}
+}
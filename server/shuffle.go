@@ -0,0 +1,135 @@
+package server
+
+import (
+	"math/rand"
+	"strings"
+
+	"github.com/mjibson/moggio/codec"
+)
+
+// reshuffle regenerates srv.Shuffle, starting with the current song so that
+// it isn't played again. With AlbumShuffle, albums are shuffled instead of
+// songs, and the songs of each album stay in queue order.
+func (srv *Server) reshuffle() {
+	n := len(srv.Queue)
+	cur := srv.PlaylistIndex
+	if !srv.AlbumShuffle {
+		srv.Shuffle = rand.Perm(n)
+		for i, v := range srv.Shuffle {
+			if v == cur {
+				srv.Shuffle[0], srv.Shuffle[i] = srv.Shuffle[i], srv.Shuffle[0]
+				break
+			}
+		}
+		return
+	}
+	// Group the queue by album, in order of first appearance.
+	var groups [][]int
+	byAlbum := make(map[string]int)
+	first := 0
+	for i, id := range srv.Queue {
+		key := string(id)
+		if d := srv.library.Get(codec.ID(id)); d != nil && d.Info.Album != "" {
+			artist := d.Info.AlbumArtist
+			if artist == "" {
+				artist = d.Info.Artist
+			}
+			key = strings.ToLower(artist) + codec.IdSep + strings.ToLower(d.Info.Album)
+		}
+		g, ok := byAlbum[key]
+		if !ok {
+			g = len(groups)
+			byAlbum[key] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+		if i == cur {
+			first = g
+		}
+	}
+	srv.Shuffle = make([]int, 0, n)
+	if len(groups) == 0 {
+		return
+	}
+	groups[0], groups[first] = groups[first], groups[0]
+	rest := groups[1:]
+	rand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+	for _, g := range groups {
+		srv.Shuffle = append(srv.Shuffle, g...)
+	}
+}
+
+// extendShuffle appends the queue indexes added since srv.Shuffle was made
+// in random order, keeping the order of those already shuffled.
+func (srv *Server) extendShuffle() {
+	n := len(srv.Shuffle)
+	if n > len(srv.Queue) {
+		srv.reshuffle()
+		return
+	}
+	for _, i := range rand.Perm(len(srv.Queue) - n) {
+		srv.Shuffle = append(srv.Shuffle, n+i)
+	}
+}
+
+// remapShuffle updates srv.Shuffle, made for the queue before an edit, to the
+// edited queue. from holds the index before the edit of each song of the
+// queue, or -1 for added songs. Removed songs are dropped from the order and
+// the others keep their places in it, so played songs aren't played again.
+// Added songs are appended in random order, as by extendShuffle.
+func (srv *Server) remapShuffle(from []int) {
+	to := make(map[int]int, len(from))
+	for i, f := range from {
+		if f >= 0 {
+			to[f] = i
+		}
+	}
+	order := make([]int, 0, len(from))
+	shuffled := make(map[int]bool, len(from))
+	for _, f := range srv.Shuffle {
+		if i, ok := to[f]; ok && !shuffled[i] {
+			order = append(order, i)
+			shuffled[i] = true
+		}
+	}
+	if len(order) == 0 {
+		srv.reshuffle()
+		return
+	}
+	var added []int
+	for i := range from {
+		if !shuffled[i] {
+			added = append(added, i)
+		}
+	}
+	rand.Shuffle(len(added), func(i, j int) {
+		added[i], added[j] = added[j], added[i]
+	})
+	srv.Shuffle = append(order, added...)
+}
+
+// shuffleStep returns the queue index delta songs from queue index idx in
+// shuffle order. It returns len(srv.Queue) past the end of the shuffle order
+// and the first song of the order before its start.
+func (srv *Server) shuffleStep(idx, delta int) int {
+	if len(srv.Shuffle) != len(srv.Queue) {
+		srv.reshuffle()
+	}
+	pos := -1
+	for i, v := range srv.Shuffle {
+		if v == idx {
+			pos = i
+			break
+		}
+	}
+	pos += delta
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= len(srv.Shuffle) {
+		return len(srv.Queue)
+	}
+	return srv.Shuffle[pos]
+}
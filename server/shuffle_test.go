@@ -0,0 +1,35 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemapShuffle(t *testing.T) {
+	tests := []struct {
+		name string
+		from []int
+		want []int
+	}{
+		{"remove", []int{1, 2, 3, 4}, []int{1, 3, 0, 2}},
+		{"move", []int{4, 0, 1, 2, 3}, []int{3, 1, 0, 2, 4}},
+		{"keep", []int{0, 1, 2, 3, 4}, []int{2, 0, 4, 1, 3}},
+	}
+	for _, test := range tests {
+		srv := &Server{
+			Queue:   Playlist{"a", "b", "c", "d", "e"},
+			Shuffle: []int{2, 0, 4, 1, 3},
+		}
+		srv.remapShuffle(test.from)
+		if !reflect.DeepEqual(srv.Shuffle, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, srv.Shuffle, test.want)
+		}
+	}
+
+	// Added songs follow the songs already in the order.
+	srv := &Server{Shuffle: []int{2, 0, 1}}
+	srv.remapShuffle([]int{0, 1, 2, -1, -1})
+	if got := srv.Shuffle; len(got) != 5 || !reflect.DeepEqual(got[:3], []int{2, 0, 1}) || got[3]+got[4] != 7 {
+		t.Errorf("add: got %v, want [2 0 1] then 3 and 4", got)
+	}
+}
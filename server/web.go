@@ -10,10 +10,13 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/mjibson/moggio/library"
 	"github.com/mjibson/moggio/protocol"
 	"golang.org/x/net/websocket"
 )
@@ -47,20 +50,38 @@ func (srv *Server) GetMux(devMode bool) *http.ServeMux {
 	}
 	indexHTML = buf.Bytes()
 	router := httprouter.New()
+	router.GET("/api/art/:hash", srv.Art)
+	router.GET("/api/browse/:view", JSON(srv.Browse))
 	router.GET("/api/cmd/:cmd", JSON(srv.Cmd))
 	router.GET("/api/data/:type", JSON(srv.Data))
+	router.GET("/api/events", srv.Events)
+	router.GET("/api/history", JSON(srv.History))
 	router.GET("/api/oauth/:protocol", srv.OAuth)
+	router.GET("/api/playlist/export/:playlist", srv.PlaylistExport)
+	router.GET("/api/protocol/errors", JSON(srv.ProtocolErrors))
+	router.GET("/api/schedule", JSON(srv.GetSchedule))
+	router.GET("/api/search", JSON(srv.Search))
+	router.GET("/api/stats", JSON(srv.Stats))
+	router.GET("/api/tracks", JSON(srv.Tracks))
+	router.GET("/api/tracks/changes", JSON(srv.TrackChanges))
 	router.POST("/api/cmd/:cmd", JSON(srv.Cmd))
 	router.POST("/api/queue/change", JSON(srv.QueueChange))
 	router.POST("/api/playlist/change/:playlist", JSON(srv.PlaylistChange))
+	router.POST("/api/playlist/smart/:playlist", JSON(srv.SmartChange))
+	router.POST("/api/playlist/import/:playlist", JSON(srv.PlaylistImport))
 	router.POST("/api/protocol/add", JSON(srv.ProtocolAdd))
 	router.POST("/api/protocol/remove", JSON(srv.ProtocolRemove))
 	router.POST("/api/protocol/refresh", JSON(srv.ProtocolRefresh))
+	router.POST("/api/protocol/cancel", JSON(srv.ProtocolCancel))
+	router.POST("/api/scrobble", JSON(srv.SetScrobble))
+	router.POST("/api/autodj", JSON(srv.SetDJ))
+	router.POST("/api/schedule", JSON(srv.SetSchedule))
 
 	mux := http.NewServeMux()
 	mux.Handle("/static/", http.FileServer(webFS))
 	mux.HandleFunc("/", Index)
 	mux.Handle("/api/", router)
+	mux.Handle("/api/v1/", srv.apiRouter())
 	mux.Handle("/ws/", websocket.Handler(srv.WebSocket))
 	return mux
 }
@@ -158,6 +179,12 @@ func (srv *Server) Cmd(body io.Reader, form url.Values, ps httprouter.Params) (i
 		srv.ch <- cmdRandom
 	case "repeat":
 		srv.ch <- cmdRepeat
+	case "autodj":
+		srv.ch <- cmdAutoDJ
+	case "album_shuffle":
+		srv.ch <- cmdAlbumShuffle
+	case "repeat_one":
+		srv.ch <- cmdRepeatOne
 	case "seek":
 		d, err := time.ParseDuration(form.Get("pos"))
 		if err != nil {
@@ -170,6 +197,52 @@ func (srv *Server) Cmd(body io.Reader, form url.Values, ps httprouter.Params) (i
 			return nil, err
 		}
 		srv.ch <- cmdMinDuration(d)
+	case "playlist_dir":
+		dir := form.Get("dir")
+		if dir != "" {
+			var err error
+			if dir, err = filepath.Abs(dir); err != nil {
+				return nil, err
+			}
+			if fi, err := os.Stat(dir); err != nil {
+				return nil, err
+			} else if !fi.IsDir() {
+				return nil, fmt.Errorf("not a directory: %s", dir)
+			}
+		}
+		srv.ch <- cmdPlaylistDir(dir)
+	case "rate":
+		c := cmdRate{
+			id:   SongID(form.Get("id")),
+			done: make(chan error, 1),
+		}
+		if s := form.Get("rating"); s != "" {
+			stars, err := parseStars(s)
+			if err != nil {
+				return nil, err
+			}
+			c.stars = &stars
+		}
+		if s := form.Get("favorite"); s != "" {
+			fav, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, err
+			}
+			c.favorite = &fav
+		}
+		srv.ch <- c
+		return nil, <-c.done
+	case "undo", "redo":
+		c := cmdUndo{
+			redo: cmd == "redo",
+			done: make(chan error, 1),
+		}
+		srv.ch <- c
+		return nil, <-c.done
+	case "history":
+		c := make(cmdGetHistory, 1)
+		srv.ch <- c
+		return <-c, nil
 	case "status":
 		sc := cmdGetStatus{status: make(chan Status)}
 		srv.ch <- sc
@@ -181,6 +254,169 @@ func (srv *Server) Cmd(body io.Reader, form url.Values, ps httprouter.Params) (i
 	return nil, nil
 }
 
+// defaultSearchLimit is the number of results returned by Search if no
+// limit is given.
+const defaultSearchLimit = 100
+
+type SearchResult struct {
+	// Total is the number of matching songs.
+	Total  int
+	Offset int
+	Songs  []SongID
+	// Items are the songs with their info, if the info parameter is set.
+	Items []listItem `json:",omitempty"`
+}
+
+// Search searches the library with the query language of library.Parse.
+// Results are paginated with the offset and limit parameters.
+func (srv *Server) Search(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	q, err := library.Parse(form.Get("q"))
+	if err != nil {
+		return nil, err
+	}
+	c := cmdSearch{
+		query: q,
+		info:  form.Get("info") != "",
+		done:  make(chan SearchResult, 1),
+	}
+	if c.offset, c.limit, err = pagination(form, defaultSearchLimit); err != nil {
+		return nil, err
+	}
+	srv.ch <- c
+	return <-c.done, nil
+}
+
+// pagination returns the offset and limit parameters of form.
+func pagination(form url.Values, defaultLimit int) (offset, limit int, err error) {
+	limit = defaultLimit
+	if s := form.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("bad offset: %s", s)
+		}
+	}
+	if s := form.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return 0, 0, fmt.Errorf("bad limit: %s", s)
+		}
+	}
+	return offset, limit, nil
+}
+
+// Browse returns a view of the library. The views are:
+//
+//	artists: all artists with their album and track counts
+//	albums: all albums, or the albums of the artist parameter
+//	album: the album named by the album and artist parameters, with its songs
+//	genres: all genres with their track counts
+//	folder: the subfolders and songs of the path parameter of the protocol
+//	        instance named by the protocol and key parameters
+func (srv *Server) Browse(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var res interface{}
+	var f func(*library.Index) error
+	switch view := ps.ByName("view"); view {
+	case "artists":
+		f = func(x *library.Index) error {
+			res = x.Artists()
+			return nil
+		}
+	case "albums":
+		f = func(x *library.Index) error {
+			res = x.Albums(form.Get("artist"))
+			return nil
+		}
+	case "album":
+		f = func(x *library.Index) error {
+			a := x.Album(form.Get("artist"), form.Get("album"))
+			if a == nil {
+				return notFound(fmt.Errorf("unknown album: %s", form.Get("album")))
+			}
+			res = a
+			return nil
+		}
+	case "genres":
+		f = func(x *library.Index) error {
+			res = x.Genres()
+			return nil
+		}
+	case "folder":
+		name, key := form.Get("protocol"), form.Get("key")
+		f = func(x *library.Index) error {
+			if _, err := srv.getInstance(name, key); err != nil {
+				return notFound(err)
+			}
+			res = x.Folder(name, key, form.Get("path"))
+			return nil
+		}
+	default:
+		return nil, notFound(fmt.Errorf("unknown view: %s", view))
+	}
+	c := cmdBrowse{
+		f:    f,
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// defaultTrackLimit is the number of songs returned by Tracks if no limit is
+// given.
+const defaultTrackLimit = 1000
+
+type TrackPage struct {
+	// Version is the library version the page is from.
+	Version uint64
+	Total   int
+	Offset  int
+	Tracks  []listItem
+}
+
+// Tracks returns the songs of the library ordered by ID. Results are
+// paginated with the offset and limit parameters. Clients that see the
+// version change between pages should fetch the changes since the first
+// page's version with TrackChanges after the last page.
+func (srv *Server) Tracks(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	c := cmdTracks{
+		done: make(chan TrackPage, 1),
+	}
+	var err error
+	if c.offset, c.limit, err = pagination(form, defaultTrackLimit); err != nil {
+		return nil, err
+	}
+	srv.ch <- c
+	return <-c.done, nil
+}
+
+// TrackChanges returns the songs changed since the library version in the
+// since parameter.
+func (srv *Server) TrackChanges(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	since, err := strconv.ParseUint(form.Get("since"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad since: %s", form.Get("since"))
+	}
+	c := cmdTrackChanges{
+		since: since,
+		done:  make(chan *LibraryDelta, 1),
+	}
+	srv.ch <- c
+	return <-c.done, nil
+}
+
+// SetDJ sets the library.DJ in the body that configures the auto-DJ.
+func (srv *Server) SetDJ(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var dj library.DJ
+	if err := json.NewDecoder(body).Decode(&dj); err != nil {
+		return nil, err
+	}
+	if err := dj.Check(); err != nil {
+		return nil, err
+	}
+	srv.ch <- cmdSetDJ(dj)
+	return nil, nil
+}
+
 type cmdGetStatus struct {
 	status chan Status
 }
@@ -190,8 +426,12 @@ func (srv *Server) QueueChange(body io.Reader, form url.Values, ps httprouter.Pa
 	if err := json.NewDecoder(body).Decode(&plc); err != nil {
 		return nil, err
 	}
-	srv.ch <- cmdQueueChange(plc)
-	return nil, nil
+	c := cmdQueueChange{
+		plc:  plc,
+		done: make(chan error, 1),
+	}
+	srv.ch <- c
+	return nil, <-c.done
 }
 
 func (srv *Server) PlaylistChange(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
@@ -199,11 +439,34 @@ func (srv *Server) PlaylistChange(body io.Reader, form url.Values, ps httprouter
 	if err := json.NewDecoder(body).Decode(&plc); err != nil {
 		return nil, err
 	}
-	srv.ch <- cmdPlaylistChange{
+	c := cmdPlaylistChange{
 		plc:  plc,
 		name: ps.ByName("playlist"),
+		done: make(chan error, 1),
 	}
-	return nil, nil
+	srv.ch <- c
+	return nil, <-c.done
+}
+
+// SmartChange sets the smart playlist to the library.Smart in the body, or
+// removes it if the body is null.
+func (srv *Server) SmartChange(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var smart *library.Smart
+	if err := json.NewDecoder(body).Decode(&smart); err != nil {
+		return nil, err
+	}
+	if smart != nil {
+		if err := smart.Check(); err != nil {
+			return nil, err
+		}
+	}
+	c := cmdSmartChange{
+		name:  ps.ByName("playlist"),
+		smart: smart,
+		done:  make(chan error, 1),
+	}
+	srv.ch <- c
+	return nil, <-c.done
 }
 
 func (srv *Server) ProtocolRefresh(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
@@ -222,6 +485,35 @@ func (srv *Server) ProtocolRefresh(body io.Reader, form url.Values, ps httproute
 	return nil, <-ch
 }
 
+// ProtocolErrors returns the files that could not be read during the last
+// refresh of a protocol instance.
+func (srv *Server) ProtocolErrors(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	c := cmdProtocolErrors{
+		protocol: form.Get("protocol"),
+		key:      form.Get("key"),
+		errs:     new([]protocol.ScanError),
+		done:     make(chan error, 1),
+	}
+	srv.ch <- c
+	if err := <-c.done; err != nil {
+		return nil, err
+	}
+	return *c.errs, nil
+}
+
+// ProtocolCancel cancels a running add or refresh of a protocol instance.
+func (srv *Server) ProtocolCancel(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
+	var pd ProtocolData
+	if err := json.NewDecoder(body).Decode(&pd); err != nil {
+		return nil, err
+	}
+	srv.ch <- cmdProtocolCancel{
+		protocol: pd.Protocol,
+		key:      pd.Key,
+	}
+	return nil, nil
+}
+
 func (srv *Server) ProtocolAdd(body io.Reader, form url.Values, ps httprouter.Params) (interface{}, error) {
 	var ap struct {
 		Protocol string
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/mjibson/moggio/codec"
+	"github.com/mjibson/moggio/library"
 	"github.com/mjibson/moggio/protocol"
 	"golang.org/x/net/websocket"
 )
@@ -21,8 +22,18 @@ const (
 	waitProtocols          = "protocols"
 	waitTracks             = "tracks"
 	waitError              = "error"
+	waitProgress           = "progress"
+	waitLibrary            = "library"
+	waitRating             = "rating"
 )
 
+type waiter struct {
+	done chan struct{}
+	// delta is set for clients that receive library changes instead of
+	// all tracks.
+	delta bool
+}
+
 // makeWaitData should only be called by the commands() function.
 func (srv *Server) makeWaitData(wt waitType) *waitData {
 	var data interface{}
@@ -43,16 +54,24 @@ func (srv *Server) makeWaitData(wt waitType) *waitData {
 			protos,
 			srv.inprogress,
 		}
-	case waitStatus:
-		data = &Status{
-			State:    srv.state,
-			Song:     srv.songID,
-			SongInfo: srv.info,
-			Elapsed:  srv.elapsed,
-			Time:     srv.info.Time,
-			Random:   srv.Random,
-			Repeat:   srv.Repeat,
+	case waitProgress:
+		// Copy since the map is modified while being sent.
+		progress := make(map[codec.ID]protocol.Progress, len(srv.progress))
+		for id, p := range srv.progress {
+			progress[id] = p
+		}
+		data = progress
+	case waitLibrary:
+		data = &LibraryDelta{
+			From:    srv.library.Version(),
+			Version: srv.library.Version(),
+			Added:   []listItem{},
+			Changed: []listItem{},
+			Removed: []SongID{},
 		}
+	case waitStatus:
+		status := srv.status()
+		data = &status
 	case waitTracks:
 		var songs []listItem
 		for name, protos := range srv.Protocols {
@@ -60,10 +79,7 @@ func (srv *Server) makeWaitData(wt waitType) *waitData {
 				sl, _ := inst.List()
 				for id, info := range sl {
 					sid := SongID(codec.NewID(name, key, string(id)))
-					songs = append(songs, listItem{
-						ID:   sid,
-						Info: info,
-					})
+					songs = append(songs, srv.listItem(sid, info))
 				}
 			}
 		}
@@ -76,12 +92,31 @@ func (srv *Server) makeWaitData(wt waitType) *waitData {
 		d := struct {
 			Queue     PlaylistInfo
 			Playlists map[string]PlaylistInfo
+			// Smart are the rules of the smart playlists in Playlists.
+			Smart map[string]library.Smart
+			// Version is the version of the queue to send with
+			// PlaylistChange to fail if another client has changed it.
+			Version uint64
+			// Versions are the versions of the playlists.
+			Versions map[string]uint64
 		}{
 			Queue:     srv.playlistInfo(srv.Queue),
 			Playlists: make(map[string]PlaylistInfo),
+			Smart:     make(map[string]library.Smart, len(srv.SmartPlaylists)),
+			Version:   srv.versions.queue,
+			Versions:  make(map[string]uint64),
+		}
+		// Copy since the map is modified while being sent.
+		for name, s := range srv.SmartPlaylists {
+			d.Smart[name] = s
 		}
 		for name, p := range srv.Playlists {
 			d.Playlists[name] = srv.playlistInfo(p)
+			d.Versions[name] = srv.versions.playlist(name)
+		}
+		for name, p := range srv.smart {
+			d.Playlists[name] = srv.playlistInfo(p)
+			d.Versions[name] = srv.versions.playlist(name)
 		}
 		data = d
 	default:
@@ -94,18 +129,61 @@ func (srv *Server) makeWaitData(wt waitType) *waitData {
 }
 
 type cmdNewWS struct {
-	ws   *websocket.Conn
-	done chan struct{}
+	ws    *websocket.Conn
+	done  chan struct{}
+	delta bool
 }
 
 type cmdDeleteWS *websocket.Conn
 
+// WebSocket sends updates to a client. Clients connecting with the delta
+// parameter set receive library messages with the changed songs instead of
+// tracks messages with all songs.
 func (srv *Server) WebSocket(ws *websocket.Conn) {
 	c := make(chan struct{})
 	srv.ch <- cmdNewWS{
-		ws:   ws,
-		done: c,
+		ws:    ws,
+		done:  c,
+		delta: ws.Request().FormValue("delta") != "",
 	}
 	for range c {
 	}
 }
+
+// LibraryDelta lists the songs changed between library versions From and
+// Version. If Reset is set, From was unknown and clients must fetch all songs
+// with the tracks API.
+type LibraryDelta struct {
+	From    uint64
+	Version uint64
+	Reset   bool `json:",omitempty"`
+	Added   []listItem
+	Changed []listItem
+	Removed []SongID
+}
+
+// libraryDelta should only be called by the commands() function.
+func (srv *Server) libraryDelta(since uint64) *LibraryDelta {
+	ld := &LibraryDelta{
+		From:    since,
+		Version: srv.library.Version(),
+		Added:   []listItem{},
+		Changed: []listItem{},
+		Removed: []SongID{},
+	}
+	d, ok := srv.library.Changes(since)
+	if !ok {
+		ld.Reset = true
+		return ld
+	}
+	for _, id := range d.Added {
+		ld.Added = append(ld.Added, srv.listItem(SongID(id), srv.library.Get(id).Info))
+	}
+	for _, id := range d.Changed {
+		ld.Changed = append(ld.Changed, srv.listItem(SongID(id), srv.library.Get(id).Info))
+	}
+	for _, id := range d.Removed {
+		ld.Removed = append(ld.Removed, SongID(id))
+	}
+	return ld
+}